@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAdminBody is the largest request body accepted by the admin api
+const maxAdminBody = 64 * 1024
+
+// registerAdmin adds the admin api handlers to the mux. They need the admin role
+// and every request is written to the audit log
+func registerAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/seeders/{name}/nodes", adminAddNodeHandler)
+	mux.HandleFunc("POST /api/v1/seeders/{name}/nodes/{addr}/crawl", adminCrawlHandler)
+	mux.HandleFunc("POST /api/v1/seeders/{name}/bans", adminBanHandler)
+	mux.HandleFunc("DELETE /api/v1/seeders/{name}/bans", adminUnbanHandler)
+}
+
+// adminAddNodeHandler adds a node to theList. The body is {"addr": "ip:port"}
+func adminAddNodeHandler(w http.ResponseWriter, r *http.Request) {
+	const action = "add node"
+	s := adminSeeder(w, r, action)
+	if s == nil {
+		return
+	}
+
+	var req struct {
+		Addr string `json:"addr"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		adminError(w, r, s, action, req.Addr, http.StatusBadRequest, err)
+		return
+	}
+	ns := s.settings()
+	na, err := ns.naFromAddr(req.Addr)
+	if err != nil {
+		adminError(w, r, s, action, req.Addr, http.StatusBadRequest, err)
+		return
+	}
+
+	k := nodeKey(na)
+	s.mtx.Lock()
+	_, dup := s.theList[k]
+	b := s.bannedBy(na)
+	switch {
+	case dup:
+		err = fmt.Errorf("node %s is already known", k)
+	case b != nil:
+		err = fmt.Errorf("node %s is banned by %s", k, b.Target)
+	case !s.addNaV2(na):
+		err = fmt.Errorf("node %s was not added. The node list may be full", k)
+	}
+	var an apiNode
+	if err == nil {
+		an = s.apiNode(k, s.theList[k])
+	}
+	s.mtx.Unlock()
+
+	if err != nil {
+		adminError(w, r, s, action, k, http.StatusConflict, err)
+		return
+	}
+	auditLog(r, s, action, k, "ok")
+	writeJSON(w, r, http.StatusCreated, an)
+}
+
+// adminCrawlHandler starts a crawl of a node now
+func adminCrawlHandler(w http.ResponseWriter, r *http.Request) {
+	const action = "crawl"
+	s := adminSeeder(w, r, action)
+	if s == nil {
+		return
+	}
+
+	k := r.PathValue("addr")
+	// there is no body but the json content type still stops cross site forms
+	if err := checkJSON(r); err != nil {
+		adminError(w, r, s, action, k, http.StatusBadRequest, err)
+		return
+	}
+	s.mtx.RLock()
+	nd, ok := s.theList[k]
+	active := ok && nd.crawlActive
+	s.mtx.RUnlock()
+
+	switch {
+	case !ok:
+		adminError(w, r, s, action, k, http.StatusNotFound, fmt.Errorf("no node %s", k))
+		return
+	case active:
+		adminError(w, r, s, action, k, http.StatusConflict, fmt.Errorf("node %s is already being crawled", k))
+		return
+	}
+
+	// the seeder goroutine starts the crawl so it is cancelled with the seeder
+	select {
+	case s.crawlNow <- k:
+	case <-s.stopped:
+		adminError(w, r, s, action, k, http.StatusServiceUnavailable, fmt.Errorf("seeder %s has stopped", s.name))
+		return
+	case <-time.After(5 * time.Second):
+		adminError(w, r, s, action, k, http.StatusServiceUnavailable, fmt.Errorf("seeder %s is busy", s.name))
+		return
+	}
+	auditLog(r, s, action, k, "ok")
+	writeJSON(w, r, http.StatusAccepted, map[string]string{"crawling": k})
+}
+
+// adminBanHandler bans an ip address, subnet or onion host. The body is
+// {"target": "192.0.2.0/24", "reason": "abuse", "duration": "72h"}. Without a
+// duration the ban never expires
+func adminBanHandler(w http.ResponseWriter, r *http.Request) {
+	const action = "ban"
+	s := adminSeeder(w, r, action)
+	if s == nil {
+		return
+	}
+
+	var req struct {
+		Target   string `json:"target"`
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		adminError(w, r, s, action, req.Target, http.StatusBadRequest, err)
+		return
+	}
+	// a ban that can not be saved would silently be lost on restart
+	if config.datadir == "" {
+		adminError(w, r, s, action, req.Target, http.StatusServiceUnavailable, fmt.Errorf("bans can not be saved without a -datadir"))
+		return
+	}
+
+	var expires *time.Time
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			adminError(w, r, s, action, req.Target, http.StatusBadRequest, fmt.Errorf("invalid duration %s", req.Duration))
+			return
+		}
+		e := time.Now().Add(d)
+		expires = &e
+	}
+
+	b, err := newBan(req.Target, req.Reason, requestUser(r).name, expires)
+	if err != nil {
+		adminError(w, r, s, action, req.Target, http.StatusBadRequest, err)
+		return
+	}
+	purged := s.addBan(b)
+
+	auditLog(r, s, action, b.Target, fmt.Sprintf("ok. %d nodes purged. reason: %s", purged, b.Reason))
+	writeJSON(w, r, http.StatusCreated, struct {
+		Ban    apiBan `json:"ban"`
+		Purged int    `json:"purged"`
+	}{newAPIBan(b.snapshot()), purged})
+}
+
+// adminUnbanHandler removes the ban for the target query parameter
+func adminUnbanHandler(w http.ResponseWriter, r *http.Request) {
+	const action = "unban"
+	s := adminSeeder(w, r, action)
+	if s == nil {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	found, err := s.removeBan(target)
+	switch {
+	case err != nil:
+		adminError(w, r, s, action, target, http.StatusBadRequest, err)
+		return
+	case !found:
+		adminError(w, r, s, action, target, http.StatusNotFound, fmt.Errorf("no ban on %s", target))
+		return
+	}
+	auditLog(r, s, action, target, "ok")
+	writeJSON(w, r, http.StatusOK, map[string]string{"unbanned": target})
+}
+
+// adminSeeder checks the request is from an admin and returns the seeder named
+// in the path. If not an error is written & audit logged and nil returned
+func adminSeeder(w http.ResponseWriter, r *http.Request, action string) *dnsseeder {
+	n := r.PathValue("name")
+	if !requireAdmin(w, r) {
+		auditLog(r, nil, action, n, "denied")
+		return nil
+	}
+	s := getSeederByName(n)
+	if s == nil {
+		adminError(w, r, nil, action, n, http.StatusNotFound, fmt.Errorf("no seeder found called %s", n))
+	}
+	return s
+}
+
+// adminError writes a json error response and audit logs the failure
+func adminError(w http.ResponseWriter, r *http.Request, s *dnsseeder, action, target string, code int, err error) {
+	auditLog(r, s, action, target, "failed: "+err.Error())
+	apiError(w, r, code, "%v", err)
+}
+
+// decodeBody decodes the json request body into v. Only json bodies are accepted
+// so html forms on other sites can not make admin requests
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := checkJSON(r); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// checkJSON returns an error unless the request has the json content type. html
+// forms can not send it so a browser can not be used to make cross site requests
+func checkJSON(r *http.Request) error {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return fmt.Errorf("content type must be application/json")
+	}
+	return nil
+}
+
+// auditMtx serializes writes to the audit log
+var auditMtx sync.Mutex
+
+// auditLog records an admin request in the log and in audit.log in the data
+// directory if there is one
+func auditLog(r *http.Request, s *dnsseeder, action, target, outcome string) {
+	name := ""
+	if s != nil {
+		name = s.name
+	}
+	entry := fmt.Sprintf("user=%q remote=%s seeder=%q action=%q target=%q result=%q",
+		requestUser(r).name, r.RemoteAddr, name, action, target, outcome)
+	log.Printf("audit - %s\n", entry)
+
+	if config.datadir == "" {
+		return
+	}
+	auditMtx.Lock()
+	defer auditMtx.Unlock()
+	f, err := os.OpenFile(filepath.Join(config.datadir, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("error opening audit log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339), entry); err != nil {
+		log.Printf("error writing audit log: %v\n", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+	"github.com/miekg/dns"
+)
+
+// adminDo runs an admin request as the user with the token and returns the status code
+func adminDo(t *testing.T, h http.Handler, method, path, token, body string) int {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func TestAdmin(t *testing.T) {
+	s := setupTestDNS(t)
+	s.crawlNow = make(chan string, 1)
+	s.stopped = make(chan struct{})
+	config.datadir = t.TempDir()
+	t.Cleanup(func() { config.datadir = "" })
+
+	const (
+		admin  = "admin-token-0123456789"
+		reader = "reader-token-0123456789"
+	)
+	auth := &webAuth{
+		tokens: map[string]string{admin: "ops", reader: "grafana"},
+		admins: map[string]bool{"ops": true},
+	}
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	registerAdmin(mux)
+	h := auth.handler(mux)
+
+	// read only users can not ban
+	ban := `{"target": "2001:db8:1::/48", "reason": "abuse report", "duration": "24h"}`
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/bans", reader, ban); code != http.StatusForbidden {
+		t.Errorf("read only ban returned %d", code)
+	}
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/bans", admin, "target=2001:db8:1::/48"); code != http.StatusBadRequest {
+		t.Errorf("form ban returned %d", code)
+	}
+
+	// banning purges the node, stops it being served and stops it being added again
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/bans", admin, ban); code != http.StatusCreated {
+		t.Fatalf("ban returned %d", code)
+	}
+	if _, ok := s.theList["[2001:db8:1::1]:9333"]; ok || len(s.theList) != 99 {
+		t.Errorf("banned node not purged. %d nodes", len(s.theList))
+	}
+	if n := len(nodeRecords("seed.example.com.", dns.TypeAAAA, 0)); n != 99 {
+		t.Errorf("%d records published after ban", n)
+	}
+	banned := wire.NewNetAddressIPPort(net.ParseIP("2001:db8:1::5"), 9333, 1)
+	if s.addNa(banned) {
+		t.Errorf("banned address added")
+	}
+
+	// bans are saved
+	restored := &dnsseeder{name: s.name}
+	if err := restored.loadBans(); err != nil || len(restored.bans) != 1 || restored.bans[0].Target != "2001:db8:1::/48" ||
+		restored.bans[0].Reason != "abuse report" || restored.bans[0].By != "ops" || restored.bans[0].Expires == nil {
+		t.Errorf("bans not restored: %v %+v", err, restored.bans)
+	}
+
+	// the api lists bans with the same keys as the other api types
+	var listed []map[string]interface{}
+	apiGet(t, mux, "/api/v1/seeders/TestNet/bans", http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0]["target"] != "2001:db8:1::/48" || listed[0]["reason"] != "abuse report" ||
+		listed[0]["by"] != "ops" || listed[0]["expires"] == nil || listed[0]["hits"] == nil {
+		t.Errorf("bans listed as %v", listed)
+	}
+
+	if code := adminDo(t, h, http.MethodDelete, "/api/v1/seeders/TestNet/bans?target="+url.QueryEscape("2001:db8:1::/48"), admin, ""); code != http.StatusOK {
+		t.Errorf("unban returned %d", code)
+	}
+	if code := adminDo(t, h, http.MethodDelete, "/api/v1/seeders/TestNet/bans?target=192.0.2.1", admin, ""); code != http.StatusNotFound {
+		t.Errorf("unban of unknown ban returned %d", code)
+	}
+
+	// nodes can be added once unbanned
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes", admin, `{"addr": "[2001:db8:1::5]:9333"}`); code != http.StatusCreated {
+		t.Errorf("add node returned %d", code)
+	}
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes", admin, `{"addr": "[2001:db8:1::5]:9333"}`); code != http.StatusConflict {
+		t.Errorf("add duplicate node returned %d", code)
+	}
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes", admin, `{"addr": "no.such.host"}`); code != http.StatusBadRequest {
+		t.Errorf("add invalid node returned %d", code)
+	}
+
+	// the seeder goroutine is asked to crawl the node
+	key := url.PathEscape("[2001:db8:1::5]:9333")
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes/"+key+"/crawl", admin, ""); code != http.StatusBadRequest {
+		t.Errorf("crawl without the json content type returned %d", code)
+	}
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes/"+key+"/crawl", admin, "{}"); code != http.StatusAccepted {
+		t.Errorf("crawl returned %d", code)
+	}
+	select {
+	case k := <-s.crawlNow:
+		if k != "[2001:db8:1::5]:9333" {
+			t.Errorf("crawl requested for %s", k)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("crawl not requested")
+	}
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/nodes/"+url.PathEscape("[2001:db8::99]:9333")+"/crawl", admin, "{}"); code != http.StatusNotFound {
+		t.Errorf("crawl of unknown node returned %d", code)
+	}
+
+	// browsers making requests for other sites are refused
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/seeders/TestNet/bans?target=192.0.2.1", nil)
+	r.Header.Set("Authorization", "Bearer "+admin)
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross site request returned %d", rec.Code)
+	}
+
+	b, err := os.ReadFile(filepath.Join(config.datadir, "audit.log"))
+	if err != nil {
+		t.Fatalf("unable to read audit log: %v", err)
+	}
+	audit := string(b)
+	for _, want := range []string{
+		`user="grafana" remote=192.0.2.1:1234 seeder="" action="ban" target="TestNet" result="denied"`,
+		`user="ops" remote=192.0.2.1:1234 seeder="TestNet" action="ban" target="2001:db8:1::/48" result="ok. 1 nodes purged. reason: abuse report"`,
+		`action="unban" target="2001:db8:1::/48" result="ok"`,
+		`action="add node" target="[2001:db8:1::5]:9333" result="failed: node [2001:db8:1::5]:9333 is already known"`,
+		`action="crawl" target="[2001:db8:1::5]:9333" result="ok"`,
+	} {
+		if !strings.Contains(audit, want) {
+			t.Errorf("audit log missing %s", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("audit log:\n%s", audit)
+	}
+
+	// bans are refused if they can not be saved
+	config.datadir = ""
+	if code := adminDo(t, h, http.MethodPost, "/api/v1/seeders/TestNet/bans", admin, ban); code != http.StatusServiceUnavailable {
+		t.Errorf("ban without a datadir returned %d", code)
+	}
+}
+
+func TestBanMatches(t *testing.T) {
+	onion := "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"
+	for _, tc := range []struct {
+		target string
+		addr   string
+		want   bool
+	}{
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.1", "192.0.2.2", false},
+		{"192.0.2.0/24", "192.0.2.200", true},
+		{"192.0.2.0/24", "192.0.3.1", false},
+		{"2001:db8::/32", "2001:db8:5::1", true},
+		{"2001:db8::/32", "192.0.2.1", false},
+		{onion, onion, true},
+		{onion, "192.0.2.1", false},
+	} {
+		b, err := newBan(tc.target, "", "", nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.target, err)
+			continue
+		}
+		na := naFromHost(tc.addr, 9333, 1, time.Now())
+		if b.matches(na) != tc.want {
+			t.Errorf("ban %s matches %s: %v", tc.target, tc.addr, !tc.want)
+		}
+	}
+
+	for _, target := range []string{"", "not an ip", "192.0.2.0/33", "bad.onion"} {
+		if _, err := newBan(target, "", "", nil); err == nil {
+			t.Errorf("invalid ban target %q accepted", target)
+		}
+	}
+
+	past := time.Now().Add(-time.Minute)
+	b, _ := newBan("192.0.2.1", "", "", &past)
+	s := &dnsseeder{bans: []*ban{b}}
+	if s.bannedBy(naFromHost("192.0.2.1", 9333, 1, time.Now())) != nil {
+		t.Errorf("expired ban applied")
+	}
+}
+
+func TestBanFile(t *testing.T) {
+	s := setupTestDNS(t)
+	s.conf.BanFile = filepath.Join(t.TempDir(), "bans.txt")
+	os.WriteFile(s.conf.BanFile, []byte(`# abuse reports
+2001:db8:2::/48 2099-01-01 abuse report 42
+2001:db8:3::1 scanning
+192.0.2.0/24 2001-01-01T00:00:00Z expired
+`), 0600)
+
+	bans, err := readBanFile(s.conf.BanFile)
+	if err != nil || len(bans) != 3 {
+		t.Fatalf("unable to read ban file: %v %d bans", err, len(bans))
+	}
+	if b := bans[0]; b.Target != "2001:db8:2::/48" || b.Reason != "abuse report 42" || b.By != banFileUser ||
+		b.Expires == nil || b.Expires.Year() != 2099 {
+		t.Errorf("subnet ban %+v", b)
+	}
+	if b := bans[1]; b.Target != "2001:db8:3::1/128" || b.Reason != "scanning" || b.Expires != nil {
+		t.Errorf("address ban %+v", b)
+	}
+
+	// banned addresses are not published even before they are purged
+	s.mtx.Lock()
+	s.fileBans = bans
+	s.mtx.Unlock()
+	s.updateDNS()
+	if n := len(nodeRecords("seed.example.com.", dns.TypeAAAA, 0)); n != 98 {
+		t.Errorf("%d records published with banned nodes", n)
+	}
+	// leaving them out of the dns records does not count as a hit
+	if bans[0].Hits != 0 || bans[1].Hits != 0 {
+		t.Errorf("ban hits counted when publishing: %d %d", bans[0].Hits, bans[1].Hits)
+	}
+
+	// loading the bans purges the banned nodes and keeps the hits
+	reloaded, _ := readBanFile(s.conf.BanFile)
+	s.setFileBans(reloaded)
+	if len(s.theList) != 98 {
+		t.Errorf("banned nodes not purged. %d nodes", len(s.theList))
+	}
+
+	// banned addresses gossiped by a node are dropped
+	k := "[2001:db8:10::1]:9333"
+	s.processResult(&result{node: k, nas: []*wire.NetAddressV2{
+		naFromHost("2001:db8:2::7", 9333, 1, time.Now()),
+		naFromHost("2001:db8:3::1", 9333, 1, time.Now()),
+		naFromHost("2001:db8:99::1", 9333, 1, time.Now()),
+	}})
+	if _, ok := s.theList["[2001:db8:99::1]:9333"]; !ok || len(s.theList) != 99 {
+		t.Errorf("gossiped addresses not added. %d nodes", len(s.theList))
+	}
+	if _, err := s.removeBan("2001:db8:3::1"); err == nil {
+		t.Errorf("ban file ban removed")
+	}
+
+	// a reload keeps the hits of the bans still in the file. The subnet ban
+	// refused one gossiped address
+	os.WriteFile(s.conf.BanFile, []byte("2001:db8:2::/48 abuse report 42\n"), 0600)
+	bans, err = readBanFile(s.conf.BanFile)
+	if err != nil {
+		t.Fatalf("unable to reload ban file: %v", err)
+	}
+	s.setFileBans(bans)
+	active := s.activeBans()
+	if len(active) != 1 || active[0].Hits != 1 {
+		t.Errorf("bans after reload %+v", active)
+	}
+
+	// the status pages show the ban hits
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest(http.MethodGet, "/statusCG?s=TestNet", nil), statusCG)
+	if body := rec.Body.String(); !strings.Contains(body, "<td>2001:db8:2::/48</td><td>abuse report 42</td><td>ban file</td><td>never</td><td>1</td>") {
+		t.Errorf("ban hits not on the status page:\n%s", body)
+	}
+
+	os.WriteFile(s.conf.BanFile, []byte("2001:db8:2::/48\nnot-an-ip\n"), 0600)
+	if _, err := readBanFile(s.conf.BanFile); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("invalid ban file loaded: %v", err)
+	}
+}
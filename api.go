@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	apiDefaultLimit = 100     // number of nodes returned in one page if no limit is given
+	apiMaxLimit     = 1000    // max number of nodes returned in one page
+	apiCatchAll     = "/api/" // pattern for the api urls no other route serves
+)
+
+// registerAPI adds the version 1 json api handlers to the mux. The api returns
+// the same information as the html pages
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/seeders", apiSeedersHandler)
+	mux.HandleFunc("GET /api/v1/seeders/{name}", apiSeederHandler)
+	mux.HandleFunc("GET /api/v1/seeders/{name}/nodes", apiNodesHandler)
+	mux.HandleFunc("GET /api/v1/seeders/{name}/nodes/{addr}", apiNodeHandler)
+	mux.HandleFunc("GET /api/v1/seeders/{name}/dns", apiDNSHandler)
+	mux.HandleFunc("GET /api/v1/seeders/{name}/bans", apiBansHandler)
+	mux.HandleFunc(apiCatchAll, apiNotFoundHandler(mux))
+}
+
+// apiSeeder is the summary of one seeder
+type apiSeeder struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	DNSHost     string            `json:"dnsHost"`
+	Chain       string            `json:"chain"`
+	Port        uint16            `json:"port"`
+	Nodes       map[string]uint32 `json:"nodes"`
+	Started     map[string]uint32 `json:"started"`
+	Total       uint32            `json:"total"`
+	DNSRequests map[string]uint32 `json:"dnsRequests"`
+	TipHeight   int32             `json:"tipHeight"`
+	Lagging     uint32            `json:"lagging"`
+	MaxBlockLag int32             `json:"maxBlockLag"`
+	Tuning      apiTuning         `json:"tuning"`
+}
+
+// apiTuning is the crawl tuning of a seeder. Delays are in seconds
+type apiTuning struct {
+	MaxSize    int                  `json:"maxSize"`
+	CrawlDelay int64                `json:"crawlDelay"`
+	AuditDelay int64                `json:"auditDelay"`
+	DNSDelay   int64                `json:"dnsDelay"`
+	MaxFails   uint32               `json:"maxFails"`
+	Status     map[string]apiStatus `json:"status"`
+}
+
+// apiStatus is the crawl tuning for one node status
+type apiStatus struct {
+	MaxStart uint32 `json:"maxStart"`
+	Delay    int64  `json:"delay"`
+}
+
+// apiNode holds the details of one node
+type apiNode struct {
+	Address      string      `json:"address"`
+	IP           string      `json:"ip,omitempty"`
+	Port         uint16      `json:"port"`
+	Status       string      `json:"status"`
+	StatusStr    string      `json:"statusStr"`
+	DNSType      string      `json:"dnsType"`
+	Good         bool        `json:"good"`
+	LastConnect  *time.Time  `json:"lastConnect,omitempty"`
+	LastTry      *time.Time  `json:"lastTry,omitempty"`
+	CrawlStart   *time.Time  `json:"crawlStart,omitempty"`
+	CrawlActive  bool        `json:"crawlActive"`
+	ConnectFails uint32      `json:"connectFails"`
+	Version      int32       `json:"version"`
+	UserAgent    string      `json:"userAgent"`
+	Services     string      `json:"services"`
+	LastBlock    int32       `json:"lastBlock"`
+	Success      uint32      `json:"success"`
+	Total        uint32      `json:"total"`
+	Uptime       []apiUptime `json:"uptime"`
+}
+
+// apiUptime is the uptime of a node over one statistics window
+type apiUptime struct {
+	Window string  `json:"window"`
+	Uptime float64 `json:"uptime"`
+	Count  float64 `json:"count"`
+}
+
+// apiNodePage is one page of the node list
+type apiNodePage struct {
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+	Nodes  []apiNode `json:"nodes"`
+}
+
+// apiBan is a ban of a seeder
+type apiBan struct {
+	Target  string     `json:"target"`
+	Reason  string     `json:"reason"`
+	Added   time.Time  `json:"added"`
+	By      string     `json:"by"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Hits    uint64     `json:"hits"`
+}
+
+// apiRecord is one published dns record
+type apiRecord struct {
+	Type     string `json:"type"`
+	Services string `json:"services"`
+	Value    string `json:"value"`
+	TTL      uint32 `json:"ttl"`
+}
+
+// apiDNS holds the records currently published for a seeder zone
+type apiDNS struct {
+	Zone    string      `json:"zone"`
+	Serial  uint32      `json:"serial"`
+	Records []apiRecord `json:"records"`
+}
+
+// apiSeedersHandler returns the summary of all seeders
+func apiSeedersHandler(w http.ResponseWriter, r *http.Request) {
+	seeders := []apiSeeder{}
+	for _, s := range activeSeeders() {
+		seeders = append(seeders, s.apiSummary())
+	}
+	writeJSON(w, r, http.StatusOK, seeders)
+}
+
+// apiSeederHandler returns the summary of one seeder
+func apiSeederHandler(w http.ResponseWriter, r *http.Request) {
+	s := apiSeederFor(w, r)
+	if s == nil {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, s.apiSummary())
+}
+
+// apiNodesHandler returns a page of the nodes for a seeder sorted by address. The
+// optional status parameter limits the nodes to one status. e.g. ?status=CG
+func apiNodesHandler(w http.ResponseWriter, r *http.Request) {
+	s := apiSeederFor(w, r)
+	if s == nil {
+		return
+	}
+
+	status := uint32(maxStatusTypes)
+	if st := r.FormValue("status"); st != "" {
+		var ok bool
+		if status, ok = str2status(st); !ok {
+			apiError(w, r, http.StatusBadRequest, "invalid status %s", st)
+			return
+		}
+	}
+	offset, err := formInt(r, "offset", 0, 0)
+	if err != nil {
+		apiError(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+	limit, err := formInt(r, "limit", apiDefaultLimit, apiMaxLimit)
+	if err != nil || limit == 0 {
+		apiError(w, r, http.StatusBadRequest, "limit must be between 1 and %d", apiMaxLimit)
+		return
+	}
+
+	s.mtx.RLock()
+	keys := make([]string, 0, len(s.theList))
+	for k, nd := range s.theList {
+		if status == maxStatusTypes || nd.status == status {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	page := apiNodePage{Total: len(keys), Offset: offset, Limit: limit, Nodes: []apiNode{}}
+	if offset < len(keys) {
+		keys = keys[offset:min(offset+limit, len(keys))]
+		for _, k := range keys {
+			page.Nodes = append(page.Nodes, s.apiNode(k, s.theList[k]))
+		}
+	}
+	s.mtx.RUnlock()
+
+	writeJSON(w, r, http.StatusOK, page)
+}
+
+// apiNodeHandler returns the details of one node
+func apiNodeHandler(w http.ResponseWriter, r *http.Request) {
+	s := apiSeederFor(w, r)
+	if s == nil {
+		return
+	}
+
+	k := r.PathValue("addr")
+	s.mtx.RLock()
+	nd, ok := s.theList[k]
+	var an apiNode
+	if ok {
+		an = s.apiNode(k, nd)
+	}
+	s.mtx.RUnlock()
+
+	if !ok {
+		apiError(w, r, http.StatusNotFound, "no node %s", k)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, an)
+}
+
+// apiDNSHandler returns the records currently published for a seeder
+func apiDNSHandler(w http.ResponseWriter, r *http.Request) {
+	s := apiSeederFor(w, r)
+	if s == nil {
+		return
+	}
+
+	zone := dns.Fqdn(s.dnsHost)
+	ad := apiDNS{Zone: zone, Records: []apiRecord{}}
+
+	config.dnsmtx.RLock()
+	if z, ok := config.dns[zone]; ok {
+		if soa := z.zone[dns.TypeSOA]; len(soa) > 0 {
+			ad.Serial = soa[0].(*dns.SOA).Serial
+		}
+		for t, svcs := range z.nodes {
+			for svc, rrs := range svcs {
+				for _, rr := range rrs {
+					ad.Records = append(ad.Records, apiRecord{
+						Type:     qtypeString(t),
+						Services: fmt.Sprintf("%016x", uint64(svc)),
+						Value:    rrValue(rr),
+						TTL:      rr.Header().Ttl,
+					})
+				}
+			}
+		}
+	}
+	config.dnsmtx.RUnlock()
+
+	sort.Slice(ad.Records, func(i, j int) bool {
+		if ad.Records[i].Type != ad.Records[j].Type {
+			return ad.Records[i].Type < ad.Records[j].Type
+		}
+		return ad.Records[i].Value < ad.Records[j].Value
+	})
+	writeJSON(w, r, http.StatusOK, ad)
+}
+
+// apiBansHandler returns the bans of a seeder that have not expired
+func apiBansHandler(w http.ResponseWriter, r *http.Request) {
+	s := apiSeederFor(w, r)
+	if s == nil {
+		return
+	}
+	bans := []apiBan{}
+	for _, b := range s.activeBans() {
+		bans = append(bans, newAPIBan(b))
+	}
+	writeJSON(w, r, http.StatusOK, bans)
+}
+
+// newAPIBan returns the api view of a ban. The saved bans keep their own format
+func newAPIBan(b ban) apiBan {
+	return apiBan{Target: b.Target, Reason: b.Reason, Added: b.Added, By: b.By, Expires: b.Expires, Hits: b.Hits}
+}
+
+// apiNotFoundHandler returns a handler writing a json error for api urls that no
+// route of the mux serves. If the url has routes for other methods then the error
+// is method not allowed and the Allow header lists the methods of those routes
+func apiNotFoundHandler(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allow := allowedMethods(mux, r); len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			apiError(w, r, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+			return
+		}
+		apiError(w, r, http.StatusNotFound, "no api endpoint %s", r.URL.Path)
+	}
+}
+
+// allowedMethods returns the methods with a route in the mux for the request url
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allow []string
+	for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		mr := r.Clone(r.Context())
+		mr.Method = m
+		if _, pattern := mux.Handler(mr); pattern != apiCatchAll {
+			allow = append(allow, m)
+		}
+	}
+	return allow
+}
+
+// apiSeederFor returns the seeder named in the request path. If there is no
+// seeder an error is written and nil returned
+func apiSeederFor(w http.ResponseWriter, r *http.Request) *dnsseeder {
+	n := r.PathValue("name")
+	s := getSeederByName(n)
+	if s == nil {
+		apiError(w, r, http.StatusNotFound, "no seeder found called %s", n)
+	}
+	return s
+}
+
+// apiSummary returns the summary of the seeder
+func (s *dnsseeder) apiSummary() apiSeeder {
+	as := apiSeeder{
+		Name:        s.name,
+		DNSHost:     s.dnsHost,
+		Nodes:       make(map[string]uint32),
+		Started:     make(map[string]uint32),
+		DNSRequests: make(map[string]uint32),
+	}
+	if s.chainParams != nil {
+		as.Chain = s.chainParams.Name
+	}
+
+	s.counts.mtx.RLock()
+	for st := uint32(0); st < maxStatusTypes; st++ {
+		if int(st) < len(s.counts.NdStatus) {
+			as.Nodes[status2str(st)] = s.counts.NdStatus[st]
+			as.Started[status2str(st)] = s.counts.NdStarts[st]
+			as.Total += s.counts.NdStatus[st]
+		}
+	}
+	for _, t := range []uint32{dnsV4Std, dnsV6Std} {
+		if int(t) < len(s.counts.DNSCounts) {
+			as.DNSRequests[dnsType2str(t)] = s.counts.DNSCounts[t]
+		}
+	}
+	as.TipHeight = s.counts.TipHeight
+	as.Lagging = s.counts.Lagging
+	s.counts.mtx.RUnlock()
+
+	s.mtx.RLock()
+	as.Description = s.desc
+	as.Port = s.port
+	as.MaxBlockLag = s.maxBlockLag
+	as.Tuning = apiTuning{
+		MaxSize:    s.maxSize,
+		CrawlDelay: int64(s.crawlDelay / time.Second),
+		AuditDelay: int64(s.auditDelay / time.Second),
+		DNSDelay:   int64(s.dnsDelay / time.Second),
+		MaxFails:   s.maxFails,
+		Status:     make(map[string]apiStatus),
+	}
+	for st := uint32(0); st < maxStatusTypes; st++ {
+		if int(st) < len(s.maxStart) && int(st) < len(s.delay) {
+			as.Tuning.Status[status2str(st)] = apiStatus{MaxStart: s.maxStart[st], Delay: s.delay[st]}
+		}
+	}
+	s.mtx.RUnlock()
+	return as
+}
+
+// apiNode returns the details of a node. The caller must hold the seeder lock
+func (s *dnsseeder) apiNode(k string, nd *node) apiNode {
+	an := apiNode{
+		Address:      k,
+		Port:         nd.na.Port,
+		Status:       status2str(nd.status),
+		StatusStr:    nd.statusStr,
+		DNSType:      nd.dns2str(),
+		Good:         s.goodNode(nd),
+		LastConnect:  apiTime(nd.lastConnect),
+		LastTry:      apiTime(nd.lastTry),
+		CrawlStart:   apiTime(nd.crawlStart),
+		CrawlActive:  nd.crawlActive,
+		ConnectFails: nd.connectFails,
+		Version:      nd.version,
+		UserAgent:    nd.strVersion,
+		Services:     fmt.Sprintf("%016x", uint64(nd.services)),
+		LastBlock:    nd.lastBlock,
+		Success:      nd.success,
+		Total:        nd.total,
+	}
+	if nd.ip != nil {
+		an.IP = nd.ip.String()
+	}
+	for i, d := range statWindows {
+		an.Uptime = append(an.Uptime, apiUptime{
+			Window: d.String(),
+			Uptime: nd.uptime(i),
+			Count:  nd.stats[i].count,
+		})
+	}
+	return an
+}
+
+// apiTime returns nil for the zero time so it is left out of the json
+func apiTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// rrValue returns the data of a published node record
+func rrValue(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	case *dns.TXT:
+		return strings.Join(v.Txt, "")
+	}
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// str2status returns the node status for a name such as CG or statusCG
+func str2status(str string) (uint32, bool) {
+	str = strings.TrimPrefix(strings.ToLower(str), "status")
+	for st := uint32(0); st < maxStatusTypes; st++ {
+		if strings.TrimPrefix(strings.ToLower(status2str(st)), "status") == str {
+			return st, true
+		}
+	}
+	return 0, false
+}
+
+// dnsType2str returns the short name of a dns type used in the api
+func dnsType2str(t uint32) string {
+	switch t {
+	case dnsV4Std:
+		return "v4std"
+	case dnsV6Std:
+		return "v6std"
+	case dnsTorV3:
+		return "torv3"
+	}
+	return "unknown"
+}
+
+// formInt returns the non-negative integer form value or def if it is not set.
+// If max is not 0 the value is limited to max
+func formInt(r *http.Request, key string, def, max int) (int, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s %s", key, v)
+	}
+	if max != 0 && i > max {
+		i = max
+	}
+	return i, nil
+}
+
+// writeJSON writes v as the json response with the status code
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding api response for %s: %v\n", r.URL.Path, err)
+	}
+	if config.verbose {
+		log.Printf("status - processed api request: %s %s %d\n", r.RemoteAddr, r.RequestURI, code)
+	}
+}
+
+// apiError writes a json error response
+func apiError(w http.ResponseWriter, r *http.Request, code int, format string, a ...interface{}) {
+	writeJSON(w, r, code, map[string]string{"error": fmt.Sprintf(format, a...)})
+}
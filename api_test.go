@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// apiGet runs a request against the api and decodes the json response into v
+func apiGet(t *testing.T, mux *http.ServeMux, path string, code int, v interface{}) {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != code {
+		t.Fatalf("%s: status %d, expected %d: %s", path, rec.Code, code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("%s: content type %q", path, ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("%s: unable to decode response: %v", path, err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := setupTestDNS(t)
+	s.maxStart = []uint32{1, 2, 3, 4, 5}
+	s.delay = []int64{10, 20, 30, 40, 50}
+
+	// the nodes after the first 90 are no good
+	var ng string
+	for i := 90; i < 100; i++ {
+		ng = fmt.Sprintf("[2001:db8:%x::1]:9333", i)
+		s.theList[ng].status = statusNG
+	}
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+
+	var seeders []apiSeeder
+	apiGet(t, mux, "/api/v1/seeders", http.StatusOK, &seeders)
+	if len(seeders) != 1 || seeders[0].Name != s.name || seeders[0].Tuning.Status["statusWC"].Delay != 50 {
+		t.Errorf("unexpected seeders %+v", seeders)
+	}
+
+	var page apiNodePage
+	apiGet(t, mux, "/api/v1/seeders/TestNet/nodes?status=CG&offset=80&limit=50", http.StatusOK, &page)
+	if page.Total != 90 || len(page.Nodes) != 10 || page.Offset != 80 || page.Limit != 50 {
+		t.Errorf("unexpected page total %d nodes %d offset %d limit %d", page.Total, len(page.Nodes), page.Offset, page.Limit)
+	}
+	for _, nd := range page.Nodes {
+		if nd.Status != "statusCG" {
+			t.Errorf("node %s has status %s", nd.Address, nd.Status)
+		}
+	}
+
+	apiGet(t, mux, "/api/v1/seeders/TestNet/nodes?limit=5000", http.StatusOK, &page)
+	if page.Total != 100 || len(page.Nodes) != 100 || page.Limit != apiMaxLimit {
+		t.Errorf("unexpected page total %d nodes %d limit %d", page.Total, len(page.Nodes), page.Limit)
+	}
+
+	var nd apiNode
+	apiGet(t, mux, "/api/v1/seeders/TestNet/nodes/"+url.PathEscape(ng), http.StatusOK, &nd)
+	if nd.Address != ng || nd.Status != "statusNG" || nd.Good || len(nd.Uptime) != maxStatWindows {
+		t.Errorf("unexpected node %+v", nd)
+	}
+
+	var ad apiDNS
+	apiGet(t, mux, "/api/v1/seeders/TestNet/dns", http.StatusOK, &ad)
+	if ad.Zone != "seed.example.com." || len(ad.Records) != 100 || ad.Records[0].Type != "AAAA" {
+		t.Errorf("unexpected dns zone %s with %d records", ad.Zone, len(ad.Records))
+	}
+
+	var apiErr map[string]string
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/api/v1/seeders/NoNet", http.StatusNotFound},
+		{"/api/v1/seeders/NoNet/nodes", http.StatusNotFound},
+		{"/api/v1/seeders/TestNet/nodes/" + url.PathEscape("[2001:db8::99]:9333"), http.StatusNotFound},
+		{"/api/v1/seeders/TestNet/nodes?status=XX", http.StatusBadRequest},
+		{"/api/v1/seeders/TestNet/nodes?offset=-1", http.StatusBadRequest},
+		{"/api/v1/seeders/TestNet/nodes?limit=0", http.StatusBadRequest},
+		{"/api/v2/seeders", http.StatusNotFound},
+	} {
+		apiErr = nil
+		apiGet(t, mux, tc.path, tc.code, &apiErr)
+		if apiErr["error"] == "" {
+			t.Errorf("%s: no error message", tc.path)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/seeders", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned status %d", rec.Code)
+	}
+}
+
+func TestAPIAllow(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	registerAdmin(mux)
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{http.MethodPost, "/api/v1/seeders", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/api/v1/seeders/TestNet/nodes", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{http.MethodPut, "/api/v1/seeders/TestNet/bans", http.StatusMethodNotAllowed, "GET, HEAD, POST, DELETE"},
+		{http.MethodGet, "/api/v1/seeders/TestNet/nodes/1.2.3.4:9333/crawl", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/api/v2/seeders", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code || rec.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: status %d allow %q, expected %d %q", tc.method, tc.path, rec.Code, rec.Header().Get("Allow"), tc.code, tc.allow)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+// ban is a banned ip address, subnet or tor onion host. Banned nodes are
+// purged from theList and never added again
+type ban struct {
+	Target  string     // ip address, cidr subnet or onion host
+	Reason  string     // why the node was banned
+	Added   time.Time  // when the ban was added
+	By      string     // user that added the ban
+	Expires *time.Time `json:",omitempty"` // the ban is lifted after this time. nil for never
+	Hits    uint64     // addresses refused because of the ban. Updated atomically
+	subnet  *net.IPNet // parsed target or nil for onion hosts
+}
+
+// banFileUser is the user recorded for bans loaded from the network ban file
+const banFileUser = "ban file"
+
+// newBan returns a ban for the target. A single ip address bans just that address
+func newBan(target, reason, by string, expires *time.Time) (*ban, error) {
+	b := &ban{Reason: reason, By: by, Added: time.Now(), Expires: expires}
+	if err := b.parse(target); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parse sets the ban target from an ip address, cidr subnet or onion host
+func (b *ban) parse(target string) error {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if strings.HasSuffix(target, ".onion") {
+		if naFromHost(target, 0, 0, time.Now()) == nil {
+			return fmt.Errorf("invalid onion host %s", target)
+		}
+		b.Target, b.subnet = target, nil
+		return nil
+	}
+
+	if !strings.Contains(target, "/") {
+		ip := net.ParseIP(target)
+		if ip == nil {
+			return fmt.Errorf("invalid ban target %s. Use an ip address, cidr subnet or onion host", target)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			target = ip4.String() + "/32"
+		} else {
+			target = ip.String() + "/128"
+		}
+	}
+	_, subnet, err := net.ParseCIDR(target)
+	if err != nil {
+		return fmt.Errorf("invalid ban subnet %s", target)
+	}
+	b.Target, b.subnet = subnet.String(), subnet
+	return nil
+}
+
+// expired returns true if the ban has been lifted
+func (b *ban) expired(now time.Time) bool {
+	return b.Expires != nil && now.After(*b.Expires)
+}
+
+// matches returns true if the network address is covered by the ban
+func (b *ban) matches(na *wire.NetAddressV2) bool {
+	if b.subnet == nil {
+		return na.Addr.String() == b.Target
+	}
+	_, ip := addrType(na)
+	return ip != nil && b.subnet.Contains(ip)
+}
+
+// snapshot returns a copy of the ban that is safe to read while hits are counted
+func (b *ban) snapshot() ban {
+	return ban{
+		Target:  b.Target,
+		Reason:  b.Reason,
+		Added:   b.Added,
+		By:      b.By,
+		Expires: b.Expires,
+		Hits:    atomic.LoadUint64(&b.Hits),
+		subnet:  b.subnet,
+	}
+}
+
+// bannedBy returns the ban covering the network address or nil if it is not
+// banned. Bans added by admins are checked before the ban file. The caller must
+// hold the seeder lock
+func (s *dnsseeder) bannedBy(na *wire.NetAddressV2) *ban {
+	now := time.Now()
+	for _, bans := range [][]*ban{s.bans, s.fileBans} {
+		for _, b := range bans {
+			if !b.expired(now) && b.matches(na) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// isBanned returns true if the network address is banned and counts a hit on the
+// ban. The caller must hold the seeder lock
+func (s *dnsseeder) isBanned(na *wire.NetAddressV2) bool {
+	b := s.bannedBy(na)
+	if b == nil {
+		return false
+	}
+	atomic.AddUint64(&b.Hits, 1)
+	return true
+}
+
+// addBan adds or replaces the ban for a target, purges the banned nodes from
+// theList and saves the bans. It returns the number of nodes purged
+func (s *dnsseeder) addBan(b *ban) int {
+	s.mtx.Lock()
+	purged := 0
+	bans := []*ban{b}
+	for _, ob := range s.bans {
+		if ob.Target != b.Target {
+			bans = append(bans, ob)
+		}
+	}
+	s.bans = bans
+	for k, nd := range s.theList {
+		if b.matches(nd.na) {
+			delete(s.theList, k)
+			purged++
+		}
+	}
+	s.mtx.Unlock()
+
+	s.saveBansLog()
+	// stop serving the banned nodes now rather than at the next dns update
+	s.updateDNS()
+	return purged
+}
+
+// removeBan removes the ban for a target and saves the bans. It returns false if
+// there was no ban for the target
+func (s *dnsseeder) removeBan(target string) (bool, error) {
+	var t ban
+	if err := t.parse(target); err != nil {
+		return false, err
+	}
+
+	s.mtx.Lock()
+	for _, b := range s.fileBans {
+		if b.Target == t.Target {
+			file := s.conf.BanFile
+			s.mtx.Unlock()
+			return false, fmt.Errorf("ban on %s is in the ban file %s", t.Target, file)
+		}
+	}
+	found := false
+	bans := s.bans[:0:0]
+	for _, b := range s.bans {
+		if b.Target == t.Target {
+			found = true
+			continue
+		}
+		bans = append(bans, b)
+	}
+	s.bans = bans
+	s.mtx.Unlock()
+
+	if found {
+		s.saveBansLog()
+	}
+	return found, nil
+}
+
+// activeBans returns the bans added by admins & loaded from the ban file that
+// have not expired
+func (s *dnsseeder) activeBans() []ban {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	now := time.Now()
+	bans := []ban{}
+	for _, b := range append(s.bans[:len(s.bans):len(s.bans)], s.fileBans...) {
+		if !b.expired(now) {
+			bans = append(bans, b.snapshot())
+		}
+	}
+	return bans
+}
+
+// setFileBans replaces the bans loaded from the ban file, keeping the hits of
+// targets that are still banned. Nodes covered by the new bans are purged and the
+// dns records updated
+func (s *dnsseeder) setFileBans(bans []*ban) {
+	s.mtx.Lock()
+	file := s.conf.BanFile
+	carryHits(bans, s.fileBans)
+	s.fileBans = bans
+	purged := 0
+	for k, nd := range s.theList {
+		if s.bannedBy(nd.na) != nil {
+			delete(s.theList, k)
+			purged++
+		}
+	}
+	s.mtx.Unlock()
+
+	log.Printf("%s: loaded %d bans from %s. %d nodes purged\n", s.name, len(bans), file, purged)
+	s.updateDNS()
+}
+
+// carryHits copies the hits from the old bans to the bans with the same target
+func carryHits(bans, old []*ban) {
+	hits := make(map[string]uint64)
+	for _, b := range old {
+		hits[b.Target] = atomic.LoadUint64(&b.Hits)
+	}
+	for _, b := range bans {
+		atomic.AddUint64(&b.Hits, hits[b.Target])
+	}
+}
+
+// pruneBans removes the expired bans and saves the bans if any were removed
+func (s *dnsseeder) pruneBans() {
+	s.mtx.Lock()
+	now := time.Now()
+	bans := s.bans[:0:0]
+	for _, b := range s.bans {
+		if b.expired(now) {
+			log.Printf("%s: ban on %s expired\n", s.name, b.Target)
+			continue
+		}
+		bans = append(bans, b)
+	}
+	pruned := len(bans) != len(s.bans)
+	s.bans = bans
+	s.mtx.Unlock()
+
+	if pruned {
+		s.saveBansLog()
+	}
+}
+
+// banFile returns the file name used to store the bans for this seeder or an
+// empty string if no data directory has been configured
+func (s *dnsseeder) banFile() string {
+	if config.datadir == "" {
+		return ""
+	}
+	return filepath.Join(config.datadir, s.name+".bans.json")
+}
+
+// saveBans writes the bans to disk via a temporary file so a crash never leaves
+// a partial file
+func (s *dnsseeder) saveBans() error {
+	fName := s.banFile()
+	if fName == "" {
+		return nil
+	}
+
+	s.mtx.RLock()
+	bans := make([]ban, 0, len(s.bans))
+	for _, b := range s.bans {
+		bans = append(bans, b.snapshot())
+	}
+	s.mtx.RUnlock()
+
+	b, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding bans: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fName), filepath.Base(fName)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating ban file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing ban file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("error closing ban file: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), fName); err != nil {
+		return fmt.Errorf("error renaming ban file: %v", err)
+	}
+	return nil
+}
+
+// saveBansLog saves the bans and logs any error
+func (s *dnsseeder) saveBansLog() {
+	if err := s.saveBans(); err != nil {
+		log.Printf("%s: unable to save bans: %v\n", s.name, err)
+	}
+}
+
+// loadBans loads the saved bans. A missing file is not an error
+func (s *dnsseeder) loadBans() error {
+	fName := s.banFile()
+	if fName == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(fName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading ban file: %v", err)
+	}
+
+	var bans []*ban
+	if err = json.Unmarshal(b, &bans); err != nil {
+		return fmt.Errorf("error decoding ban file %s: %v", fName, err)
+	}
+	for _, b := range bans {
+		if err := b.parse(b.Target); err != nil {
+			return fmt.Errorf("error in ban file %s: %v", fName, err)
+		}
+	}
+
+	s.mtx.Lock()
+	s.bans = bans
+	s.mtx.Unlock()
+	return nil
+}
+
+// readBanFile reads a ban list. Each line is an ip address, cidr subnet or onion
+// host followed by an optional expiry date and reason, e.g.
+//
+//	192.0.2.0/24 2026-12-31 abuse report
+//
+// The expiry is a date or an RFC 3339 time. Blank lines & lines starting with #
+// are skipped
+func readBanFile(file string) ([]*ban, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ban file: %v", err)
+	}
+	defer fh.Close()
+
+	var bans []*ban
+	sc := bufio.NewScanner(fh)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var expires *time.Time
+		if len(fields) > 1 {
+			if e, ok := parseExpiry(fields[1]); ok {
+				expires = &e
+				fields = append(fields[:1], fields[2:]...)
+			}
+		}
+		b, err := newBan(fields[0], strings.Join(fields[1:], " "), banFileUser, expires)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", file, n, err)
+		}
+		bans = append(bans, b)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ban file: %v", err)
+	}
+	return bans, nil
+}
+
+// parseExpiry parses a ban expiry as a date or an RFC 3339 time
+func parseExpiry(v string) (time.Time, bool) {
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
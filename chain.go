@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ltcsuite/ltcd/blockchain"
+	"github.com/ltcsuite/ltcd/chaincfg"
+	"github.com/ltcsuite/ltcd/chaincfg/chainhash"
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+// maxPowChecks is the max number of headers after a checkpoint that have their
+// proof of work checked. Checking scrypt hashes is slow
+const maxPowChecks = 16
+
+// checkpoint is a known block on the network chain. Nodes are asked for the
+// headers following it to prove they are on the same chain
+type checkpoint struct {
+	height int32
+	hash   chainhash.Hash
+	bits   uint32 // compact target of the block. 0 if not known
+}
+
+// knownChains are the chains that can be named in the network file Chain field
+var knownChains = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet4Params,
+	&chaincfg.RegressionNetParams,
+	&chaincfg.SigNetParams,
+	&chaincfg.SimNetParams,
+}
+
+// chainParams returns the chain parameters for a network file. If no chain is
+// named then the network magic must match a known chain. Custom chains are built
+// from the mainnet parameters with the magic, port & genesis hash of the network
+func chainParams(jnw JNetwork, id wire.BitcoinNet) (*chaincfg.Params, error) {
+	chain := strings.ToLower(jnw.Chain)
+	switch chain {
+	case "":
+		for _, p := range knownChains {
+			if p.Net == id {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("no chain for network magic %v. Set Chain in the network file", id)
+	case "custom":
+		if id == 0 || jnw.Port == 0 {
+			return nil, fmt.Errorf("custom chain needs the network ID and port")
+		}
+		genesis, err := chainhash.NewHashFromStr(jnw.Genesis)
+		if err != nil || len(jnw.Genesis) != chainhash.MaxHashStringSize {
+			return nil, fmt.Errorf("invalid genesis hash %s", jnw.Genesis)
+		}
+		p := chaincfg.MainNetParams
+		p.Name = jnw.Name
+		p.Net = id
+		p.DefaultPort = strconv.Itoa(int(jnw.Port))
+		p.GenesisHash = genesis
+		p.Checkpoints = nil
+		p.DNSSeeds = nil
+		return &p, nil
+	}
+
+	for _, p := range knownChains {
+		if p.Name != chain {
+			continue
+		}
+		if id != 0 && id != p.Net {
+			return nil, fmt.Errorf("network magic %v does not match chain %s", id, chain)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown chain %s", jnw.Chain)
+}
+
+// checkpointFor returns the highest checkpoint below the last block reported by
+// a node or nil if the node can not be checked
+func (ns *netSettings) checkpointFor(lastBlock int32) *checkpoint {
+	var cp *checkpoint
+	for i := range ns.checkpoints {
+		if c := &ns.checkpoints[i]; c.height < lastBlock && (cp == nil || c.height > cp.height) {
+			cp = c
+		}
+	}
+	return cp
+}
+
+// belowCheckpoints returns why a node can not be checked because its last block
+// is not above any checkpoint or an empty string. Such a node could be on any
+// chain so it is treated as on the wrong chain until it reports a higher block
+func (ns *netSettings) belowCheckpoints(lastBlock int32) string {
+	if len(ns.checkpoints) == 0 {
+		return ""
+	}
+	lowest := ns.checkpoints[0].height
+	for _, c := range ns.checkpoints {
+		lowest = min(lowest, c.height)
+	}
+	return fmt.Sprintf("last block %d is not above the lowest checkpoint %d", lastBlock, lowest)
+}
+
+// getHeadersMsg returns a getheaders message asking for the headers after the checkpoint
+func getHeadersMsg(cp *checkpoint) *wire.MsgGetHeaders {
+	m := wire.NewMsgGetHeaders()
+	m.AddBlockLocatorHash(&cp.hash)
+	return m
+}
+
+// verifyHeaders checks the headers returned for a getheaders request from the
+// checkpoint. The headers must connect to the checkpoint and to each other and
+// the first maxPowChecks must meet their target and the network limit. If the
+// checkpoint target is known the header targets must follow it. They can only
+// change at a retarget height and never become easier than the retarget factor
+// allows, so a cheap chain built on the checkpoint hash fails. Networks that allow
+// minimum difficulty blocks can also use the network limit
+func verifyHeaders(cp *checkpoint, headers []*wire.BlockHeader, params *chaincfg.Params) error {
+	if len(headers) == 0 {
+		return fmt.Errorf("no headers after checkpoint %d", cp.height)
+	}
+
+	var maxTarget *big.Int
+	if cp.bits != 0 {
+		maxTarget = new(big.Int).Mul(blockchain.CompactToBig(cp.bits), big.NewInt(params.RetargetAdjustmentFactor))
+	}
+	interval := int32(params.TargetTimespan / params.TargetTimePerBlock)
+
+	prev := cp.hash
+	bits := cp.bits
+	for i, h := range headers {
+		height := cp.height + int32(i) + 1
+		if h.PrevBlock != prev {
+			return fmt.Errorf("header %d does not connect to %s", height, prev)
+		}
+		if i < maxPowChecks {
+			target := blockchain.CompactToBig(h.Bits)
+			if target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+				return fmt.Errorf("header %d target %08x is outside the network limit", height, h.Bits)
+			}
+			minDifficulty := params.ReduceMinDifficulty && h.Bits == params.PowLimitBits
+			if maxTarget != nil && !minDifficulty {
+				retarget := !params.PoWNoRetargeting && interval > 0 && height%interval == 0
+				if h.Bits != bits && !retarget {
+					return fmt.Errorf("header %d target %08x does not follow the checkpoint target %08x", height, h.Bits, bits)
+				}
+				if target.Cmp(maxTarget) > 0 {
+					return fmt.Errorf("header %d target %08x is easier than the checkpoint target %08x allows", height, h.Bits, cp.bits)
+				}
+				bits = h.Bits
+			}
+			pow := h.PowHash()
+			if blockchain.HashToBig(&pow).Cmp(target) > 0 {
+				return fmt.Errorf("header %d does not meet its proof of work target", height)
+			}
+		}
+		prev = h.BlockHash()
+	}
+	return nil
+}
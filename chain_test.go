@@ -0,0 +1,198 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ltcsuite/ltcd/blockchain"
+	"github.com/ltcsuite/ltcd/chaincfg"
+	"github.com/ltcsuite/ltcd/chaincfg/chainhash"
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+// mineHeader returns a header after prev whose proof of work does or does not
+// meet the target
+func mineHeader(t *testing.T, prev chainhash.Hash, bits uint32, good bool) *wire.BlockHeader {
+	h := wire.NewBlockHeader(1, &prev, &chainhash.Hash{}, bits, 0)
+	h.Timestamp = time.Unix(1700000000, 0)
+	target := blockchain.CompactToBig(h.Bits)
+	for ; h.Nonce < 100000; h.Nonce++ {
+		pow := h.PowHash()
+		if (blockchain.HashToBig(&pow).Cmp(target) <= 0) == good {
+			return h
+		}
+	}
+	t.Fatalf("unable to mine header")
+	return nil
+}
+
+func TestVerifyHeaders(t *testing.T) {
+	regtest := &chaincfg.RegressionNetParams
+	cp := &checkpoint{height: 100, hash: chainhash.DoubleHashH([]byte("checkpoint"))}
+
+	var headers []*wire.BlockHeader
+	prev := cp.hash
+	for i := 0; i < 5; i++ {
+		h := mineHeader(t, prev, 0x207fffff, true)
+		headers = append(headers, h)
+		prev = h.BlockHash()
+	}
+	if err := verifyHeaders(cp, headers, regtest); err != nil {
+		t.Errorf("valid headers failed: %v", err)
+	}
+
+	if err := verifyHeaders(cp, nil, regtest); err == nil {
+		t.Errorf("empty headers passed")
+	}
+
+	// headers from a node that does not know the checkpoint start at its genesis block
+	other := &checkpoint{height: 100, hash: chainhash.DoubleHashH([]byte("fork"))}
+	if err := verifyHeaders(other, headers, regtest); err == nil {
+		t.Errorf("headers not connected to the checkpoint passed")
+	}
+
+	// easy targets are not allowed on networks with a lower limit
+	if err := verifyHeaders(cp, headers, &chaincfg.MainNetParams); err == nil {
+		t.Errorf("headers above the network proof of work limit passed")
+	}
+
+	bad := append(headers[:2:2], mineHeader(t, headers[1].BlockHash(), 0x207fffff, false))
+	if err := verifyHeaders(cp, bad, regtest); err == nil {
+		t.Errorf("header without enough proof of work passed")
+	}
+}
+
+func TestVerifyHeadersTarget(t *testing.T) {
+	// a network without minimum difficulty blocks
+	params := chaincfg.RegressionNetParams
+	params.ReduceMinDifficulty = false
+	params.PoWNoRetargeting = false
+	interval := int32(params.TargetTimespan / params.TargetTimePerBlock)
+
+	// the second header is at a retarget height
+	cp := &checkpoint{height: 10*interval - 2, hash: chainhash.DoubleHashH([]byte("checkpoint")), bits: 0x2000ffff}
+	chain := func(bits ...uint32) []*wire.BlockHeader {
+		var headers []*wire.BlockHeader
+		prev := cp.hash
+		for _, b := range bits {
+			h := mineHeader(t, prev, b, true)
+			headers = append(headers, h)
+			prev = h.BlockHash()
+		}
+		return headers
+	}
+
+	for _, tc := range []struct {
+		name string
+		bits []uint32
+		ok   bool
+	}{
+		{"same target", []uint32{0x2000ffff, 0x2000ffff, 0x2000ffff}, true},
+		{"retarget", []uint32{0x2000ffff, 0x2001ffff, 0x2001ffff}, true},
+		{"easier target", []uint32{0x207fffff, 0x207fffff}, false},
+		{"change between retargets", []uint32{0x2000ffff, 0x2000ffff, 0x2001ffff}, false},
+		{"retarget too easy", []uint32{0x2000ffff, 0x2007ffff}, false},
+	} {
+		if err := verifyHeaders(cp, chain(tc.bits...), &params); (err == nil) != tc.ok {
+			t.Errorf("%s: unexpected result %v", tc.name, err)
+		}
+	}
+
+	// minimum difficulty blocks are allowed where the network allows them
+	cheap := chain(0x207fffff, 0x2000ffff)
+	if err := verifyHeaders(cp, cheap, &chaincfg.RegressionNetParams); err != nil {
+		t.Errorf("minimum difficulty header failed: %v", err)
+	}
+	// without a checkpoint target only the network limit is checked
+	if err := verifyHeaders(&checkpoint{height: cp.height, hash: cp.hash}, cheap, &params); err != nil {
+		t.Errorf("headers failed without a checkpoint target: %v", err)
+	}
+}
+
+func TestCheckpointFor(t *testing.T) {
+	s := &dnsseeder{netSettings: netSettings{checkpoints: []checkpoint{{height: 1000}, {height: 5000}, {height: 3000}}}}
+	for _, tc := range []struct {
+		lastBlock int32
+		want      int32
+	}{
+		{500, 0},
+		{1000, 0},
+		{1001, 1000},
+		{4000, 3000},
+		{9000, 5000},
+	} {
+		cp := s.checkpointFor(tc.lastBlock)
+		switch {
+		case cp == nil && tc.want != 0:
+			t.Errorf("no checkpoint for block %d, expected %d", tc.lastBlock, tc.want)
+		case cp != nil && cp.height != tc.want:
+			t.Errorf("checkpoint %d for block %d, expected %d", cp.height, tc.lastBlock, tc.want)
+		}
+		// nodes that can not be checked are not trusted
+		if cp == nil && s.belowCheckpoints(tc.lastBlock) == "" {
+			t.Errorf("node at block %d passed without a checkpoint", tc.lastBlock)
+		}
+	}
+
+	none := &dnsseeder{}
+	if none.checkpointFor(10) != nil || none.belowCheckpoints(10) != "" {
+		t.Errorf("node failed without checkpoints")
+	}
+}
+
+func TestChainParams(t *testing.T) {
+	genesis := chaincfg.MainNetParams.GenesisHash.String()
+	for _, tc := range []struct {
+		jnw  JNetwork
+		id   wire.BitcoinNet
+		want string
+		ok   bool
+	}{
+		{JNetwork{}, wire.MainNet, "mainnet", true},
+		{JNetwork{}, wire.TestNet4, "testnet4", true},
+		{JNetwork{}, wire.BitcoinNet(0x01020304), "", false},
+		{JNetwork{Chain: "Regtest"}, 0, "regtest", true},
+		{JNetwork{Chain: "signet"}, 0, "signet", true},
+		{JNetwork{Chain: "mainnet"}, wire.TestNet4, "", false},
+		{JNetwork{Chain: "nonet"}, 0, "", false},
+		{JNetwork{Name: "fork", Chain: "custom", Port: 9333, Genesis: genesis}, wire.BitcoinNet(0x01020304), "fork", true},
+		{JNetwork{Name: "fork", Chain: "custom", Port: 9333}, wire.BitcoinNet(0x01020304), "", false},
+		{JNetwork{Name: "fork", Chain: "custom", Genesis: genesis}, wire.BitcoinNet(0x01020304), "", false},
+	} {
+		p, err := chainParams(tc.jnw, tc.id)
+		if (err == nil) != tc.ok {
+			t.Errorf("chain %q magic %v: unexpected error %v", tc.jnw.Chain, tc.id, err)
+			continue
+		}
+		if err == nil && p.Name != tc.want {
+			t.Errorf("chain %q magic %v: got %s, expected %s", tc.jnw.Chain, tc.id, p.Name, tc.want)
+		}
+	}
+
+	// custom chains do not change the mainnet parameters
+	p, _ := chainParams(JNetwork{Name: "fork", Chain: "custom", Port: 1234, Genesis: genesis}, wire.BitcoinNet(0x01020304))
+	if p.Net != wire.BitcoinNet(0x01020304) || p.DefaultPort != "1234" || chaincfg.MainNetParams.DefaultPort != "9333" {
+		t.Errorf("unexpected custom chain params %v %v", p.Net, p.DefaultPort)
+	}
+}
+
+func TestCheckpointBits(t *testing.T) {
+	hash := chainhash.DoubleHashH([]byte("checkpoint")).String()
+	for bits, ok := range map[string]bool{
+		"":           true,
+		"2000ffff":   true,
+		"0x207fffff": true,
+		"217fffff":   false,
+		"00000000":   false,
+		"zz":         false,
+	} {
+		jnw := JNetwork{Name: "A", Chain: "regtest", DNSName: "a.example.com", Checkpoints: []JCheckpoint{{Height: 10, Hash: hash, Bits: bits}}}
+		s, err := initNetwork(jnw)
+		if (err == nil) != ok {
+			t.Errorf("bits %q: unexpected error %v", bits, err)
+		}
+		if err == nil && bits != "" && s.checkpoints[0].bits == 0 {
+			t.Errorf("bits %q not set", bits)
+		}
+	}
+}
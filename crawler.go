@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/ltcsuite/ltcd/chaincfg"
+	"github.com/btcsuite/go-socks/socks"
 	"github.com/ltcsuite/ltcd/peer"
 	"github.com/ltcsuite/ltcd/wire"
 )
@@ -20,6 +20,7 @@ const (
 	manualMsgLimit    = 20
 	manualConnTimeout = 10 * time.Second
 	maxAddrMessages   = 50
+	headersTimeout    = 6 * time.Second
 )
 
 type crawlError struct {
@@ -31,34 +32,70 @@ func (e *crawlError) Error() string {
 	return fmt.Sprintf("crawl error at %s: %v", e.loc, e.err)
 }
 
-func crawlNode(rc chan<- *result, s *dnsseeder, nd *node) {
-	addr := net.JoinHostPort(nd.na.IP.String(), strconv.Itoa(int(nd.na.Port)))
-	res := &result{node: addr}
+// crawlNode connects to a node, asks for its addresses and sends the result to
+// the seeder. The crawl is cancelled if it takes longer than maxTo seconds or the
+// context is cancelled
+func crawlNode(ctx context.Context, rc chan<- *result, s *dnsseeder, nd *node) {
+	res := &result{node: nodeKey(nd.na)}
 
-	peers, cerr := crawlIP(s, res)
+	// crawl with the settings at the start so a reload does not change them mid crawl
+	ns := s.settings()
+
+	// fail the crawl without dialing if we have no route to the node network
+	if !ns.canReach(nd.dnsType) {
+		res.msg = &crawlError{"dial", fmt.Errorf("no proxy configured to reach %s", nd.dns2str())}
+		sendResult(ctx, rc, res)
+		return
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*maxTo)
+	defer cancel()
+
+	peers, cerr := crawlIP(cctx, s, &ns, res)
 	if cerr != nil {
 		res.msg = cerr
 	}
 	res.nas = peers
-	rc <- res
+	sendResult(ctx, rc, res)
+}
+
+// sendResult sends the crawl result to the seeder. The result is dropped if the
+// seeder has shut down
+func sendResult(ctx context.Context, rc chan<- *result, res *result) {
+	select {
+	case rc <- res:
+	case <-ctx.Done():
+	}
 }
 
 // crawlIP attempts to fetch addresses via ltcd Peer handshake, falling back to manual wire protocol.
-func crawlIP(s *dnsseeder, r *result) ([]*wire.NetAddress, *crawlError) {
-	if peers, ok := fetchViaPeer(s, r); ok {
+func crawlIP(ctx context.Context, s *dnsseeder, ns *netSettings, r *result) ([]*wire.NetAddressV2, *crawlError) {
+	if peers, ok := fetchViaPeer(ctx, s, ns, r); ok {
 		return peers, nil
 	}
-	return fetchViaManual(s, r)
+	if err := ctx.Err(); err != nil {
+		return nil, &crawlError{"cancelled", err}
+	}
+	return fetchViaManual(ctx, s, ns, r)
 }
 
-// fetchViaPeer tries the newer ltcd Peer abstraction.
-func fetchViaPeer(s *dnsseeder, r *result) ([]*wire.NetAddress, bool) {
+// fetchViaPeer tries the newer ltcd Peer abstraction. The peer sends sendaddrv2
+// during the handshake so nodes that support BIP155 reply with addrv2 messages.
+func fetchViaPeer(ctx context.Context, s *dnsseeder, ns *netSettings, r *result) ([]*wire.NetAddressV2, bool) {
 	verack := make(chan struct{}, 1)
-	addrCh := make(chan []*wire.NetAddress, 1)
+	addrCh := make(chan []*wire.NetAddressV2, 1)
+	headersCh := make(chan []*wire.BlockHeader, 1)
 
 	cfg := &peer.Config{
 		UserAgentName: "ltcseeder",
 		Services:      0,
+		// onion host names can not be parsed as ip addresses by the peer
+		HostToNetAddress: func(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
+			if na := naFromHost(host, port, services, time.Now()); na != nil {
+				return na, nil
+			}
+			return nil, fmt.Errorf("unsupported host %s", host)
+		},
 		Listeners: peer.MessageListeners{
 			OnVersion: func(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgReject {
 				debugLog(s.name, "version", r.node, fmt.Errorf("protocol %d", msg.ProtocolVersion))
@@ -70,17 +107,30 @@ func fetchViaPeer(s *dnsseeder, r *result) ([]*wire.NetAddress, bool) {
 				verack <- struct{}{}
 			},
 			OnAddr: func(p *peer.Peer, msg *wire.MsgAddr) {
+				select {
+				case addrCh <- legacyAddrs(msg.AddrList):
+				default:
+				}
+			},
+			OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
 				select {
 				case addrCh <- msg.AddrList:
 				default:
 				}
 			},
+			OnHeaders: func(p *peer.Peer, msg *wire.MsgHeaders) {
+				select {
+				case headersCh <- msg.Headers:
+				default:
+				}
+			},
 		},
 	}
-	if s.port == 9333 {
-		cfg.ChainParams = &chaincfg.MainNetParams
-	} else {
-		cfg.ChainParams = &chaincfg.TestNet4Params
+	cfg.ChainParams = s.chainParams
+
+	if isOnion(r.node) {
+		// stops the peer sending the proxy address in the version message
+		cfg.Proxy = ns.onionProxy
 	}
 
 	p, err := peer.NewOutboundPeer(cfg, r.node)
@@ -90,86 +140,154 @@ func fetchViaPeer(s *dnsseeder, r *result) ([]*wire.NetAddress, bool) {
 	defer p.WaitForDisconnect()
 	defer p.Disconnect()
 
-	network := dialNetwork(p.Addr())
-	conn, err := net.Dial(network, p.Addr())
+	conn, err := ns.dialNode(ctx, p.Addr(), dialTimeout)
 	if err != nil {
 		return nil, false
 	}
 	p.AssociateConnection(conn)
 
+	// disconnecting the peer closes the connection and stops the crawl
+	stop := context.AfterFunc(ctx, p.Disconnect)
+	defer stop()
+
 	select {
 	case <-verack:
 	case <-time.After(verAckTimeout):
 		return nil, false
+	case <-ctx.Done():
+		return nil, false
 	}
 
 	p.QueueMessage(wire.NewMsgGetAddr(), nil)
 
+	var addrs []*wire.NetAddressV2
 	select {
-	case addrs := <-addrCh:
+	case addrs = <-addrCh:
 		debugLog(s.name, "addr", r.node, fmt.Errorf("%d peers", len(addrs)))
-		if len(addrs) > 0 {
-			return addrs, true
-		}
 	case <-time.After(peerAddrTimeout):
 		debugLog(s.name, "addr timeout", r.node, nil)
+	case <-ctx.Done():
+	}
+	if len(addrs) == 0 {
+		return nil, false
+	}
+
+	// prove the node is on our chain if we have a checkpoint below its last block
+	cp := ns.checkpointFor(r.lastBlock)
+	if cp == nil {
+		r.wrongChain = ns.belowCheckpoints(r.lastBlock)
+		return addrs, true
+	}
+	p.QueueMessage(getHeadersMsg(cp), nil)
+	select {
+	case headers := <-headersCh:
+		if err := verifyHeaders(cp, headers, s.chainParams); err != nil {
+			r.wrongChain = err.Error()
+		}
+		return addrs, true
+	case <-time.After(headersTimeout):
+		debugLog(s.name, "headers timeout", r.node, nil)
+	case <-ctx.Done():
 	}
 	return nil, false
 }
 
 // fetchViaManual falls back to raw wire protocol for legacy nodes.
-func fetchViaManual(s *dnsseeder, r *result) ([]*wire.NetAddress, *crawlError) {
-	ctx, cancel := context.WithTimeout(context.Background(), manualConnTimeout)
-	defer cancel()
-	d := &net.Dialer{}
-	conn, err := d.DialContext(ctx, "tcp", r.node)
+func fetchViaManual(ctx context.Context, s *dnsseeder, ns *netSettings, r *result) ([]*wire.NetAddressV2, *crawlError) {
+	conn, err := ns.dialNode(ctx, r.node, manualConnTimeout)
 	if err != nil {
 		debugLog(s.name, "manual dial", r.node, err)
 		return nil, &crawlError{"manual dial", err}
 	}
 	defer conn.Close()
 
-	if err := conn.SetDeadline(time.Now().Add(ioDeadline())); err != nil {
-		return nil, &crawlError{"set deadline", err}
+	// closing the connection unblocks any read or write when the crawl is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, &crawlError{"set deadline", err}
+		}
 	}
 
-	me := wire.NewNetAddress(conn.LocalAddr().(*net.TCPAddr), wire.SFNodeNetwork)
-	you := wire.NewNetAddress(conn.RemoteAddr().(*net.TCPAddr), wire.SFNodeNetwork)
+	me := connNetAddress(conn.LocalAddr())
+	you := connNetAddress(conn.RemoteAddr())
 
 	// handshake
-	if err := wire.WriteMessage(conn, wire.NewMsgVersion(me, you, nounce, 0), s.pver, s.id); err != nil {
+	if err := wire.WriteMessage(conn, wire.NewMsgVersion(me, you, nounce, 0), ns.pver, s.id); err != nil {
 		return nil, &crawlError{"write version", err}
 	}
-	msg, _, err := wire.ReadMessage(conn, s.pver, s.id)
+	msg, _, err := wire.ReadMessage(conn, ns.pver, s.id)
 	if err != nil {
 		return nil, &crawlError{"read version", err}
 	}
-	if _, ok := msg.(*wire.MsgVersion); !ok {
+	ver, ok := msg.(*wire.MsgVersion)
+	if !ok {
 		return nil, &crawlError{"version type", fmt.Errorf("%T", msg)}
 	}
+	r.version, r.services, r.lastBlock, r.strVersion =
+		ver.ProtocolVersion, ver.Services, ver.LastBlock, ver.UserAgent
 
-	if err := wire.WriteMessage(conn, wire.NewMsgVerAck(), s.pver, s.id); err != nil {
+	// BIP155 - sendaddrv2 must be sent before our verack
+	if uint32(ver.ProtocolVersion) >= wire.AddrV2Version {
+		if err := wire.WriteMessage(conn, wire.NewMsgSendAddrV2(), ns.pver, s.id); err != nil {
+			return nil, &crawlError{"write sendaddrv2", err}
+		}
+	}
+
+	if err := wire.WriteMessage(conn, wire.NewMsgVerAck(), ns.pver, s.id); err != nil {
 		return nil, &crawlError{"write verack", err}
 	}
 
-	if err := waitForVerAck(conn, s, r); err != nil {
+	if err := waitForVerAck(conn, s, ns, r); err != nil {
 		return nil, err
 	}
 
-	if err := wire.WriteMessage(conn, wire.NewMsgGetAddr(), s.pver, s.id); err != nil {
+	if err := wire.WriteMessage(conn, wire.NewMsgGetAddr(), ns.pver, s.id); err != nil {
 		return nil, &crawlError{"write getaddr", err}
 	}
 
-	peers := collectAddrs(conn, s, r)
-	if len(peers) > 0 {
+	peers := collectAddrs(conn, s, ns, r)
+	if len(peers) == 0 {
+		return nil, &crawlError{"no addrs", fmt.Errorf("no peers after manual fetch")}
+	}
+
+	// prove the node is on our chain if we have a checkpoint below its last block
+	cp := ns.checkpointFor(r.lastBlock)
+	if cp == nil {
+		r.wrongChain = ns.belowCheckpoints(r.lastBlock)
 		return peers, nil
 	}
-	return nil, &crawlError{"no addrs", fmt.Errorf("no peers after manual fetch")}
+	if err := wire.WriteMessage(conn, getHeadersMsg(cp), ns.pver, s.id); err != nil {
+		return nil, &crawlError{"write getheaders", err}
+	}
+	headers, cerr := waitForHeaders(conn, s, ns)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err := verifyHeaders(cp, headers, s.chainParams); err != nil {
+		r.wrongChain = err.Error()
+	}
+	return peers, nil
+}
+
+func waitForHeaders(conn net.Conn, s *dnsseeder, ns *netSettings) ([]*wire.BlockHeader, *crawlError) {
+	for i := 0; i < manualMsgLimit; i++ {
+		msg, _, err := wire.ReadMessage(conn, ns.pver, s.id)
+		if err != nil {
+			continue
+		}
+		if m, ok := msg.(*wire.MsgHeaders); ok {
+			return m.Headers, nil
+		}
+	}
+	return nil, &crawlError{"headers wait", fmt.Errorf("headers not received in %d msgs", manualMsgLimit)}
 }
 
-func waitForVerAck(conn net.Conn, s *dnsseeder, r *result) *crawlError {
+func waitForVerAck(conn net.Conn, s *dnsseeder, ns *netSettings, r *result) *crawlError {
 	for i := 0; i < manualMsgLimit; i++ {
-		msg, _, err := wire.ReadMessage(conn, s.pver, s.id)
+		msg, _, err := wire.ReadMessage(conn, ns.pver, s.id)
 		if err != nil {
 			continue
 		}
@@ -180,24 +298,106 @@ func waitForVerAck(conn net.Conn, s *dnsseeder, r *result) *crawlError {
 	return &crawlError{"verack wait", fmt.Errorf("verack not received in %d msgs", manualMsgLimit)}
 }
 
-func collectAddrs(conn net.Conn, s *dnsseeder, r *result) []*wire.NetAddress {
-	var peers []*wire.NetAddress
+func collectAddrs(conn net.Conn, s *dnsseeder, ns *netSettings, r *result) []*wire.NetAddressV2 {
+	var peers []*wire.NetAddressV2
 	for i := 0; i < maxAddrMessages; i++ {
-		msg, _, err := wire.ReadMessage(conn, s.pver, s.id)
+		msg, _, err := wire.ReadMessage(conn, ns.pver, s.id)
 		if err != nil {
 			continue
 		}
-		if addrMsg, ok := msg.(*wire.MsgAddr); ok {
+		switch addrMsg := msg.(type) {
+		case *wire.MsgAddr:
 			debugLog(s.name, "addr", r.node, fmt.Errorf("%d peers", len(addrMsg.AddrList)))
+			peers = append(peers, legacyAddrs(addrMsg.AddrList)...)
+		case *wire.MsgAddrV2:
+			debugLog(s.name, "addrv2", r.node, fmt.Errorf("%d peers", len(addrMsg.AddrList)))
 			peers = append(peers, addrMsg.AddrList...)
-			if len(peers) > 1 {
-				break
-			}
+		}
+		if len(peers) > 1 {
+			break
 		}
 	}
 	return peers
 }
 
+// legacyAddrs converts addresses from an addr message to the addrv2 format
+func legacyAddrs(addrs []*wire.NetAddress) []*wire.NetAddressV2 {
+	nas := make([]*wire.NetAddressV2, 0, len(addrs))
+	for _, na := range addrs {
+		nas = append(nas, naFromLegacy(na))
+	}
+	return nas
+}
+
+// canReach returns true if we are able to connect to nodes of the dns type. I2P
+// is not supported as ltcd skips I2P addresses so there are no nodes to reach
+func (ns *netSettings) canReach(dnsType uint32) bool {
+	switch dnsType {
+	case dnsV4Std, dnsV6Std:
+		return true
+	case dnsTorV3:
+		return ns.onionProxy != ""
+	}
+	return false
+}
+
+// dialNode connects to a node. Onion addresses are connected through the socks5
+// onion proxy and all others are dialed directly
+func (ns *netSettings) dialNode(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	if isOnion(addr) {
+		if ns.onionProxy == "" {
+			return nil, fmt.Errorf("no onion proxy configured")
+		}
+		// use a new tor circuit for each node
+		proxy := &socks.Proxy{Addr: ns.onionProxy, TorIsolation: true}
+		return dialProxy(ctx, proxy, addr, timeout)
+	}
+	d := &net.Dialer{Timeout: timeout}
+	return d.DialContext(ctx, dialNetwork(addr), addr)
+}
+
+// dialProxy dials through a socks5 proxy. The proxy does not take a context so
+// the dial runs in a goroutine and a connection made after the context is done
+// is closed
+func dialProxy(ctx context.Context, proxy *socks.Proxy, addr string, timeout time.Duration) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	dc := make(chan dialed, 1)
+	go func() {
+		conn, err := proxy.DialTimeout("tcp", addr, timeout)
+		dc <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-dc:
+		return d.conn, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-dc; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// isOnion returns true if addr is a tor onion host:port
+func isOnion(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && strings.HasSuffix(host, ".onion")
+}
+
+// connNetAddress returns the wire address for a connection end point. Proxied
+// connections do not have a tcp address so an unroutable address is used
+func connNetAddress(addr net.Addr) *wire.NetAddress {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return wire.NewNetAddress(tcpAddr, wire.SFNodeNetwork)
+	}
+	return wire.NewNetAddressIPPort(net.IPv4zero, 0, wire.SFNodeNetwork)
+}
+
 func dialNetwork(addr string) string {
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -209,10 +409,6 @@ func dialNetwork(addr string) string {
 	return "tcp4"
 }
 
-func ioDeadline() time.Duration {
-	return time.Second * maxTo
-}
-
 func debugLog(nodeName, phase, addr string, info error) {
 	if config.debug {
 		if info != nil {
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ltcsuite/ltcd/chaincfg"
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+// socksStandIn is a minimal socks5 server that connects every CONNECT request to
+// target and records the host names requested
+type socksStandIn struct {
+	ln     net.Listener
+	target string
+	hosts  chan string
+}
+
+func newSocksStandIn(t *testing.T, target string) *socksStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	p := &socksStandIn{ln: ln, target: target, hosts: make(chan string, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting: version, number of methods, methods. Reply with no auth
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type domain, length, host, port
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil || buf[1] != 1 || buf[3] != 3 {
+		return
+	}
+	l := int(buf[4])
+	if _, err := io.ReadFull(conn, buf[:l+2]); err != nil {
+		return
+	}
+	p.hosts <- net.JoinHostPort(string(buf[:l]), strconv.Itoa(int(binary.BigEndian.Uint16(buf[l:l+2]))))
+
+	dst, err := net.Dial("tcp", p.target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer dst.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+	go io.Copy(dst, conn)
+	io.Copy(conn, dst)
+}
+
+func TestDialOnionViaProxy(t *testing.T) {
+	// the node behind the proxy says hello and hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	proxy := newSocksStandIn(t, ln.Addr().String())
+
+	pubKey := make([]byte, wire.TorV3Size)
+	onion := wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork, pubKey, 9333)
+	addr := nodeKey(onion)
+
+	s := &dnsseeder{name: "TestNet"}
+	if s.canReach(dnsTorV3) {
+		t.Errorf("tor nodes reachable without a proxy")
+	}
+	if _, err := s.dialNode(context.Background(), addr, time.Second); err == nil {
+		t.Errorf("onion address dialed without a proxy")
+	}
+
+	s.onionProxy = proxy.ln.Addr().String()
+	if !s.canReach(dnsTorV3) {
+		t.Errorf("tor nodes not reachable with a proxy")
+	}
+
+	conn, err := s.dialNode(context.Background(), addr, time.Second)
+	if err != nil {
+		t.Fatalf("unable to dial %s via proxy: %v", addr, err)
+	}
+	defer conn.Close()
+
+	if h := <-proxy.hosts; h != addr {
+		t.Errorf("proxy asked to connect to %s, expected %s", h, addr)
+	}
+
+	b, err := io.ReadAll(conn)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("unexpected data from proxied node: %q %v", b, err)
+	}
+
+	// proxied connections do not have tcp addresses
+	if na := connNetAddress(conn.RemoteAddr()); na == nil || !na.IP.Equal(net.IPv4zero) {
+		t.Errorf("unexpected address for proxied connection: %v", na)
+	}
+}
+
+func TestCrawlCancel(t *testing.T) {
+	// the node accepts connections and never says anything
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := &dnsseeder{
+		name:        "TestNet",
+		id:          wire.TestNet,
+		chainParams: &chaincfg.RegressionNetParams,
+		netSettings: netSettings{
+			pver:    70016,
+			maxSize: 10,
+		},
+	}
+	s.theList = make(map[string]*node)
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	s.addNa(wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), port, 1))
+	nd := s.theList[ln.Addr().String()]
+
+	// a cancelled crawl returns promptly with the reason
+	ctx, cancel := context.WithCancel(context.Background())
+	cerrs := make(chan *crawlError, 1)
+	go func() {
+		_, cerr := crawlIP(ctx, s, &s.netSettings, &result{node: nodeKey(nd.na)})
+		cerrs <- cerr
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case cerr := <-cerrs:
+		if cerr == nil || cerr.loc != "cancelled" {
+			t.Errorf("unexpected error for a cancelled crawl: %v", cerr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("cancelled crawl did not return")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("cancelled crawl took %s to return", d)
+	}
+
+	// the crawl does not block sending a result nobody will read
+	ctx, cancel = context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		crawlNode(ctx, make(chan *result), s, nd)
+		close(finished)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatalf("crawl leaked after the seeder shut down")
+	}
+}
@@ -2,118 +2,79 @@ package main
 
 import (
 	"log"
+	"math/rand"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ltcsuite/ltcd/wire"
 	"github.com/miekg/dns"
 )
 
-// serviceDef defines a DNS subdomain prefix and the required service flags.
-type serviceDef struct {
-	prefix string
-	flags  []wire.ServiceFlag
-}
-
-var serviceDefs = []serviceDef{
-	// (none)
-	// std: no special services required
-	{"", []wire.ServiceFlag{}},
-	// NETWORK
-	{"x1", []wire.ServiceFlag{wire.SFNodeNetwork}},
-	// NETWORK | GETUTXO
-	{"x3", []wire.ServiceFlag{wire.SFNodeNetwork, 2}},
-	// NETWORK | BLOOM
-	{"x5", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeBloom}},
-	// NETWORK | WITNESS
-	{"x9", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeWitness}},
-	// NETWORK | BLOOM | WITNESS
-	{"xd", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeBloom, wire.SFNodeWitness}},
-	// NETWORK | COMPACT_FILTERS
-	{"x41", []wire.ServiceFlag{wire.SFNodeNetwork, 64}},
-	// NETWORK | WITNESS | COMPACT_FILTERS
-	{"x49", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeWitness, 64}},
-	// NETWORK_LIMITED
-	{"x400", []wire.ServiceFlag{1024}},
-	// NETWORK_LIMITED | GETUTXO
-	{"x403", []wire.ServiceFlag{1024, 2}},
-	// NETWORK_LIMITED | BLOOM
-	{"x404", []wire.ServiceFlag{1024, wire.SFNodeBloom}},
-	// NETWORK_LIMITED | WITNESS
-	{"x408", []wire.ServiceFlag{1024, wire.SFNodeWitness}},
-	// NETWORK_LIMITED | BLOOM | WITNESS
-	{"x40c", []wire.ServiceFlag{1024, wire.SFNodeBloom, wire.SFNodeWitness}},
-	// NETWORK_LIMITED | COMPACT_FILTERS
-	{"x440", []wire.ServiceFlag{1024, 64}},
-	// NETWORK_LIMITED | WITNESS | COMPACT_FILTERS
-	{"x448", []wire.ServiceFlag{1024, wire.SFNodeWitness, 64}},
-	// NETWORK | WITNESS | MWEB
-	{"x1000009", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeWitness, 16777216}},
-	// NETWORK | WITNESS | COMPACT_FILTERS | MWEB
-	{"x1000049", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeWitness, 64, 16777216}},
-	// NETWORK_LIMITED | WITNESS | MWEB
-	{"x1000408", []wire.ServiceFlag{1024, wire.SFNodeWitness, 16777216}},
-	// NETWORK_LIMITED | WITNESS | COMPACT_FILTERS | MWEB
-	{"x1000448", []wire.ServiceFlag{1024, wire.SFNodeWitness, 64, 16777216}},
-	// NETWORK | WITNESS | COMPACT_FILTERS | MWEB | MWEB_LIGHT_CLIENT
-	{"x1800049", []wire.ServiceFlag{wire.SFNodeNetwork, wire.SFNodeWitness, 64, 16777216, 8388608}},
-	// NETWORK_LIMITED | WITNESS | COMPACT_FILTERS | MWEB | MWEB_LIGHT_CLIENT
-	{"x1800448", []wire.ServiceFlag{1024, wire.SFNodeWitness, 64, 16777216, 8388608}},
+// ednsUDPSize is the largest udp answer we will send to an EDNS0 client. This is the
+// size recommended by DNS flag day 2020 to avoid ip fragmentation
+const ednsUDPSize = 1232
+
+// dnsZone holds the records currently published for one seeder zone. Node records
+// are indexed by the exact services of the node so a query for any combination
+// of service flags can be answered without building every combination up front
+type dnsZone struct {
+	zone  map[uint16][]dns.RR                      // SOA & NS records for the zone apex
+	nodes map[uint16]map[wire.ServiceFlag][]dns.RR // node records by type & node services
 }
 
 // updateDNS builds and publishes DNS records for a seeder.
 func (s *dnsseeder) updateDNS() {
-	records := make(map[string][]dns.RR)
+	zone := dns.Fqdn(s.dnsHost)
+	z := &dnsZone{
+		zone:  make(map[uint16][]dns.RR),
+		nodes: make(map[uint16]map[wire.ServiceFlag][]dns.RR),
+	}
 
-	// Collect both A and AAAA records based on nd.dnsType
-	// and register both "x" and "0x" prefix variants
 	s.mtx.RLock()
-	for _, nd := range s.theList {
-		if nd.status != statusCG {
-			continue
+	for _, nd := range s.publishNodes() {
+		var rr dns.RR
+		if nd.dnsType == dnsTorV3 {
+			rr = onionRecord(zone, nodeKey(nd.na), s.ttl)
+		} else {
+			rr = nodeRecord(zone, nd.ip, nd.dnsType, s.ttl)
 		}
-
-		// Determine record type
-		var recType uint16
-		switch nd.dnsType {
-		case dnsV4Std:
-			recType = dns.TypeA
-		case dnsV6Std:
-			recType = dns.TypeAAAA
-		default:
+		if rr == nil {
 			continue
 		}
 
-		// Iterate service definitions
-		for _, def := range serviceDefs {
-			if !hasAllFlags(nd.services, def.flags...) {
-				continue
-			}
+		t := rr.Header().Rrtype
+		if z.nodes[t] == nil {
+			z.nodes[t] = make(map[wire.ServiceFlag][]dns.RR)
+		}
+		z.nodes[t][nd.services] = append(z.nodes[t][nd.services], rr)
+	}
 
-			// Build both plain and "0x" prefixed subdomains
-			prefixes := []string{def.prefix}
-			if def.prefix != "" {
-				prefixes = append(prefixes, "0"+def.prefix)
-			}
+	// the zone serial is the time of this update
+	s.addZoneRecords(z, uint32(time.Now().Unix()))
+	s.mtx.RUnlock()
 
-			// Append records for each prefix variant
-			for _, pref := range prefixes {
-				addRecord(records, pref, s.dnsHost, nd.na.IP, recType, s.ttl)
-			}
+	records := make(map[string]int)
+	for t, svcs := range z.nodes {
+		for _, rrs := range svcs {
+			records[qtypeString(t)] += len(rrs)
 		}
 	}
-	s.mtx.RUnlock()
+	s.metrics.published(records)
 
 	if config.debug {
-		for key, slice := range records {
-			log.Printf("debug - %s: %d records", key, len(slice))
+		for t, svcs := range z.nodes {
+			for svc, rrs := range svcs {
+				log.Printf("debug - %s: %s x%x: %d records", s.name, qtypeString(t), uint64(svc), len(rrs))
+			}
 		}
 	}
 
 	// Publish updated records
 	config.dnsmtx.Lock()
-	for key, slice := range records {
-		config.dns[key] = slice
-	}
+	config.dns[zone] = z
 	config.dnsmtx.Unlock()
 }
 
@@ -122,48 +83,114 @@ func updateDNS(s *dnsseeder) {
 	s.updateDNS()
 }
 
-// hasAllFlags checks if svc contains all provided flags.
-func hasAllFlags(svc wire.ServiceFlag, flags ...wire.ServiceFlag) bool {
-	for _, f := range flags {
-		if svc&f == 0 {
-			return false
+// addZoneRecords adds the SOA & NS records for the seeder zone. The caller must
+// hold the seeder lock
+func (s *dnsseeder) addZoneRecords(z *dnsZone, serial uint32) {
+	zone := dns.Fqdn(s.dnsHost)
+
+	// the primary name server is the first configured name server or the zone itself
+	primary := zone
+	if len(s.nameServers) > 0 {
+		primary = s.nameServers[0]
+	}
+
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: s.ttl},
+		Ns:      primary,
+		Mbox:    s.mbox,
+		Serial:  serial,
+		Refresh: s.soaRefresh,
+		Retry:   s.soaRetry,
+		Expire:  s.soaExpire,
+		Minttl:  s.soaMinTTL,
+	}
+	z.zone[dns.TypeSOA] = []dns.RR{soa}
+
+	var nsRRs []dns.RR
+	for _, ns := range s.nameServers {
+		nsRRs = append(nsRRs, &dns.NS{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: s.ttl},
+			Ns:  ns,
+		})
+	}
+	z.zone[dns.TypeNS] = nsRRs
+}
+
+// negativeSOA returns the SOA record to add to the authority section of empty or
+// negative answers. The TTL is the lower of the SOA TTL & minimum. RFC 2308 Sec. 3
+func (s *dnsseeder) negativeSOA() []dns.RR {
+	rrs := zoneRecords(dns.Fqdn(s.dnsHost), dns.TypeSOA)
+	if len(rrs) == 0 {
+		return nil
+	}
+	soa := dns.Copy(rrs[0]).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return []dns.RR{soa}
+}
+
+// nodeRecord returns an A or AAAA record for a node or nil if the node can not be
+// published in dns
+func nodeRecord(name string, ip net.IP, dnsType uint32, ttl uint32) dns.RR {
+	switch dnsType {
+	case dnsV4Std:
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   ip,
+		}
+	case dnsV6Std:
+		return &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: ip,
 		}
 	}
-	return true
+	return nil
+}
+
+// goodNode returns true if the node can be handed out to clients. It must be
+// confirmed good, pass the seeder policy and be up to date with the chain. The
+// caller must hold the seeder lock
+func (s *dnsseeder) goodNode(nd *node) bool {
+	return nd.status == statusCG && s.policy.good(nd) && !s.behindTip(nd)
 }
 
-// addRecord appends an A or AAAA record under records map.
-func addRecord(records map[string][]dns.RR, prefix, host string, ip net.IP, recordType uint16, ttl uint32) {
-	name := host + "."
-	if prefix != "" {
-		name = prefix + "." + host + "."
+// publishNodes returns the good nodes that pass the seeder policy. If
+// there are more than pool nodes of a dns type then the most reliable are
+// returned. The caller must hold the seeder lock
+func (s *dnsseeder) publishNodes() []*node {
+	var nds []*node
+	for _, nd := range s.theList {
+		// banned nodes are never published even before the audit purges them. They
+		// are not counted as ban hits as nothing was refused
+		if s.goodNode(nd) && s.bannedBy(nd.na) == nil {
+			nds = append(nds, nd)
+		}
 	}
-	var rr dns.RR
-	switch recordType {
-	case dns.TypeA:
-		rec := &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}}
-		rec.A = ip
-		rr = rec
-	case dns.TypeAAAA:
-		rec := &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}}
-		rec.AAAA = ip
-		rr = rec
-	default:
-		return
+	if s.pool <= 0 {
+		return nds
 	}
-	recordKey := name + recordSuffix(recordType)
-	records[recordKey] = append(records[recordKey], rr)
+
+	sort.SliceStable(nds, func(i, j int) bool { return nds[i].score() > nds[j].score() })
+
+	var counts [maxDNSTypes]int
+	pub := nds[:0]
+	for _, nd := range nds {
+		if counts[nd.dnsType] < s.pool {
+			counts[nd.dnsType]++
+			pub = append(pub, nd)
+		}
+	}
+	return pub
 }
 
-// recordSuffix returns the DNS type suffix for map keys.
-func recordSuffix(t uint16) string {
-	switch t {
-	case dns.TypeA:
-		return "A"
-	case dns.TypeAAAA:
-		return "AAAA"
+// onionRecord returns a TXT record holding the host:port of a tor node. Onion
+// addresses can not be served as A or AAAA records
+func onionRecord(name, addr string, ttl uint32) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		Txt: []string{addr},
 	}
-	return ""
 }
 
 // handleDNS answers incoming DNS queries.
@@ -171,20 +198,302 @@ func handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 	resp := &dns.Msg{MsgHdr: dns.MsgHdr{Authoritative: true, RecursionAvailable: false}}
 	resp.SetReply(r)
 
+	// without EDNS0 udp answers are limited to 512 bytes. RFC 1035 Sec. 4.2.1
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		// we only understand EDNS version 0. RFC 6891 Sec. 6.1.3
+		if opt.Version() != 0 {
+			resp.SetEdns0(ednsUDPSize, opt.Do())
+			resp.Rcode = dns.RcodeBadVers
+			w.WriteMsg(resp)
+			return
+		}
+		if cs := int(opt.UDPSize()); cs > size {
+			size = cs
+		}
+		if size > ednsUDPSize {
+			size = ednsUDPSize
+		}
+		// echo the OPT record with the buffer size we are prepared to use
+		resp.SetEdns0(ednsUDPSize, opt.Do())
+	}
+	if isTCP(w) {
+		size = dns.MaxMsgSize
+	}
+
+	// we only answer one question per request
+	if len(r.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		w.WriteMsg(resp)
+		return
+	}
+
 	q := r.Question[0]
-	resp.Answer = lookupRecords(q.Name, q.Qtype)
+	name := strings.ToLower(q.Name)
+
+	s := getSeederByZone(name)
+	if s == nil {
+		// not one of our zones so we are not authoritative. RFC 8906 Sec. 3.1.3.1
+		resp.Authoritative = false
+		resp.Rcode = dns.RcodeRefused
+		w.WriteMsg(resp)
+		go updateDNSCounts(nil, qtypeString(q.Qtype))
+		return
+	}
+
+	s.mtx.RLock()
+	onionLabel, maxAnswers := s.onionLabel, s.maxAnswers
+	s.mtx.RUnlock()
+
+	zone := dns.Fqdn(s.dnsHost)
+	f, ok := parseQueryName(name, zone, onionLabel)
+	if !ok {
+		// a name in our zone that we never serve records for
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = s.negativeSOA()
+		resp.Truncate(size)
+		w.WriteMsg(resp)
+		s.metrics.query(qtypeString(q.Qtype), "invalid", resp.Rcode, resp.Len())
+		go updateDNSCounts(s, qtypeString(q.Qtype))
+		return
+	}
+
+	var rrs []dns.RR
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeTXT:
+		if f.wants(q.Qtype) {
+			rrs = nodeRecords(zone, q.Qtype, f.services)
+		}
+	case dns.TypeSOA, dns.TypeNS:
+		if name == zone {
+			rrs = zoneRecords(zone, q.Qtype)
+		}
+	}
+
+	resp.Answer = ownerRecords(shuffleRecords(rrs, f.limit(maxAnswers)), q.Name)
+	// empty answers carry the zone SOA so resolvers can cache them
+	if len(resp.Answer) == 0 {
+		resp.Ns = s.negativeSOA()
+	}
+
+	// drop records that do not fit and set the TC bit so the client retries over tcp
+	resp.Truncate(size)
 	w.WriteMsg(resp)
+	s.metrics.query(qtypeString(q.Qtype), queryPrefix(name, zone, onionLabel), resp.Rcode, resp.Len())
 	// record stats async
-	go updateDNSCounts(q.Name, qtypeString(q.Qtype))
+	go updateDNSCounts(s, qtypeString(q.Qtype))
+}
+
+// dnsFilter holds the filters requested by the labels of a query name. The labels
+// follow the bitcoin-seeder style and can be combined in any order, e.g.
+// n5.x9.seed.example.com
+type dnsFilter struct {
+	services wire.ServiceFlag // x<hex> - services the nodes must support
+	realm    uint64           // r<hex> - bitmask of network types to return
+	max      int              // l<max> or n<count> - max number of records to return
+	onion    bool             // onion label - return tor nodes as TXT records
+}
+
+const (
+	// network type bits used by the r<realm> filter. There is no I2P bit as ltcd
+	// can not decode I2P addresses so there are never I2P nodes to serve
+	realmIPv4  = 1 << 0
+	realmIPv6  = 1 << 1
+	realmOnion = 1 << 2
+	realmAll   = realmIPv4 | realmIPv6 | realmOnion
+)
+
+// parseQueryName returns the filters requested by a query name in zone. ok is
+// false if the name contains labels we do not support. onionLabel is the label
+// that selects the tor node TXT records
+func parseQueryName(name, zone, onionLabel string) (f dnsFilter, ok bool) {
+	f.realm = realmAll
+	if name == zone {
+		return f, true
+	}
+
+	sub := strings.TrimSuffix(name, "."+zone)
+	if sub == name {
+		return f, false
+	}
+
+	seen := make(map[byte]bool)
+	for _, label := range strings.Split(sub, ".") {
+		if label == onionLabel {
+			if f.onion {
+				return f, false
+			}
+			f.onion = true
+			continue
+		}
+
+		// 0x<hex> is an alias for x<hex>
+		if strings.HasPrefix(label, "0x") {
+			label = label[1:]
+		}
+		if len(label) < 2 {
+			return f, false
+		}
+
+		// l & n both limit the number of results so only one is allowed
+		key := label[0]
+		if key == 'l' {
+			key = 'n'
+		}
+		if seen[key] {
+			return f, false
+		}
+		seen[key] = true
+
+		switch label[0] {
+		case 'x':
+			v, err := strconv.ParseUint(label[1:], 16, 64)
+			if err != nil {
+				return f, false
+			}
+			f.services = wire.ServiceFlag(v)
+		case 'r':
+			v, err := strconv.ParseUint(label[1:], 16, 64)
+			if err != nil || v == 0 || v&^realmAll != 0 {
+				return f, false
+			}
+			f.realm = v
+		case 'l', 'n':
+			v, err := strconv.Atoi(label[1:])
+			if err != nil || v <= 0 || v > maxAnswers {
+				return f, false
+			}
+			f.max = v
+		default:
+			return f, false
+		}
+	}
+	return f, true
+}
+
+// wants returns true if records of type qtype are served at the name. Tor nodes
+// are only served as TXT records below the onion label & ip nodes everywhere else
+func (f dnsFilter) wants(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeA:
+		return !f.onion && f.realm&realmIPv4 != 0
+	case dns.TypeAAAA:
+		return !f.onion && f.realm&realmIPv6 != 0
+	case dns.TypeTXT:
+		return f.onion && f.realm&realmOnion != 0
+	}
+	return false
+}
+
+// limit returns the number of records to return for the filter
+func (f dnsFilter) limit(def int) int {
+	if f.max > 0 && f.max < def {
+		return f.max
+	}
+	return def
 }
 
-// lookupRecords fetches cached DNS records or returns empty slice.
-// lookupRecords fetches cached DNS records or returns empty slice.
-func lookupRecords(name string, qtype uint16) []dns.RR {
-	key := name + qtypeString(qtype)
+// isTCP returns true if the request was received over tcp
+func isTCP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
+
+// zoneRecords returns the published SOA or NS records for a zone
+func zoneRecords(zone string, qtype uint16) []dns.RR {
 	config.dnsmtx.RLock()
 	defer config.dnsmtx.RUnlock()
-	return config.dns[key]
+	if z, ok := config.dns[zone]; ok {
+		return z.zone[qtype]
+	}
+	return nil
+}
+
+// nodeRecords returns the published node records of type qtype for a zone where
+// the node services include all the flags in mask. The returned slice is always
+// a new slice so may be modified by the caller
+func nodeRecords(zone string, qtype uint16, mask wire.ServiceFlag) []dns.RR {
+	config.dnsmtx.RLock()
+	defer config.dnsmtx.RUnlock()
+
+	z, ok := config.dns[zone]
+	if !ok {
+		return nil
+	}
+	var rrs []dns.RR
+	for svc, srrs := range z.nodes[qtype] {
+		if svc&mask == mask {
+			rrs = append(rrs, srrs...)
+		}
+	}
+	return rrs
+}
+
+// ownerRecords returns copies of rrs with the owner name set to name. The
+// published records are shared by all requests so must not be changed
+func ownerRecords(rrs []dns.RR, name string) []dns.RR {
+	answer := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		answer[i] = dns.Copy(rr)
+		answer[i].Header().Name = name
+	}
+	return answer
+}
+
+// shuffleRecords returns up to max records picked at random from rrs. The records
+// are grouped by network range (/16 for ipv4, /32 for ipv6) and taken from each
+// group in turn so clients receive peers from as many ranges as possible.
+// rrs is never modified as it is shared by all dns requests
+func shuffleRecords(rrs []dns.RR, max int) []dns.RR {
+	if len(rrs) == 0 {
+		return rrs
+	}
+
+	groups := make(map[string][]dns.RR)
+	for _, rr := range rrs {
+		k := netGroup(rr)
+		groups[k] = append(groups[k], rr)
+	}
+
+	buckets := make([][]dns.RR, 0, len(groups))
+	for _, g := range groups {
+		rand.Shuffle(len(g), func(i, j int) { g[i], g[j] = g[j], g[i] })
+		buckets = append(buckets, g)
+	}
+	rand.Shuffle(len(buckets), func(i, j int) { buckets[i], buckets[j] = buckets[j], buckets[i] })
+
+	if max > len(rrs) {
+		max = len(rrs)
+	}
+	answer := make([]dns.RR, 0, max)
+
+	// take one record from each group per pass until we have enough
+	for i := 0; len(answer) < max; i++ {
+		for _, b := range buckets {
+			if i < len(b) {
+				answer = append(answer, b[i])
+				if len(answer) == max {
+					break
+				}
+			}
+		}
+	}
+	return answer
+}
+
+// netGroup returns the network range a record belongs to. /16 for ipv4 and /32 for ipv6
+func netGroup(rr dns.RR) string {
+	switch r := rr.(type) {
+	case *dns.A:
+		if ip := r.A.To4(); ip != nil {
+			return string(ip[:2])
+		}
+	case *dns.AAAA:
+		if ip := r.AAAA.To16(); ip != nil {
+			return string(ip[:4])
+		}
+	}
+	return ""
 }
 
 func qtypeString(qtype uint16) string {
@@ -199,14 +508,20 @@ func qtypeString(qtype uint16) string {
 		return "MX"
 	case dns.TypeNS:
 		return "NS"
+	case dns.TypeSOA:
+		return "SOA"
 	}
 	return "UNKNOWN"
 }
 
-// serve starts a DNS server on the given network and port.
-func serve(network, port string) {
+// serve starts a DNS server on the given network and port in a goroutine and
+// returns it so it can be shut down.
+func serve(network, port string) *dns.Server {
 	server := &dns.Server{Addr: ":" + port, Net: network}
-	if err := server.ListenAndServe(); err != nil {
-		log.Printf("failed to setup %s server: %v", network, err)
-	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("failed to setup %s server: %v", network, err)
+		}
+	}()
+	return server
 }
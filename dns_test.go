@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+	"github.com/miekg/dns"
+)
+
+func TestShuffleRecords(t *testing.T) {
+	var rrs []dns.RR
+
+	// 40 addresses in 10.1.0.0/16 and one address in each of 5 other ranges
+	for i := 0; i < 40; i++ {
+		rrs = append(rrs, nodeRecord("seed.example.com.", net.ParseIP(fmt.Sprintf("10.1.0.%d", i+1)).To4(), dnsV4Std, 60))
+	}
+	for i := 0; i < 5; i++ {
+		rrs = append(rrs, nodeRecord("seed.example.com.", net.ParseIP(fmt.Sprintf("10.%d.0.1", i+2)).To4(), dnsV4Std, 60))
+	}
+	first := rrs[0]
+
+	answer := shuffleRecords(rrs, 10)
+	if len(answer) != 10 {
+		t.Fatalf("expected 10 records, got %d", len(answer))
+	}
+	if rrs[0] != first || len(rrs) != 45 {
+		t.Errorf("shuffleRecords modified the shared record slice")
+	}
+
+	seen := make(map[string]bool)
+	groups := make(map[string]bool)
+	for _, rr := range answer {
+		ip := rr.(*dns.A).A.String()
+		if seen[ip] {
+			t.Errorf("duplicate record in answer: %s", ip)
+		}
+		seen[ip] = true
+		groups[netGroup(rr)] = true
+	}
+	if len(groups) != 6 {
+		t.Errorf("expected records from 6 network ranges, got %d", len(groups))
+	}
+
+	if answer = shuffleRecords(rrs[:3], 10); len(answer) != 3 {
+		t.Errorf("expected 3 records when fewer are available, got %d", len(answer))
+	}
+}
+
+// testWriter is a dns.ResponseWriter that keeps the written message
+type testWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (tw *testWriter) LocalAddr() net.Addr         { return tw.remote }
+func (tw *testWriter) RemoteAddr() net.Addr        { return tw.remote }
+func (tw *testWriter) WriteMsg(m *dns.Msg) error   { tw.msg = m; return nil }
+func (tw *testWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (tw *testWriter) Close() error                { return nil }
+func (tw *testWriter) TsigStatus() error           { return nil }
+func (tw *testWriter) TsigTimersOnly(bool)         {}
+func (tw *testWriter) Hijack()                     {}
+
+var (
+	udpClient = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5353}
+	tcpClient = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5353}
+)
+
+// setupTestDNS configures a single seeder serving 100 AAAA records
+func setupTestDNS(t *testing.T) *dnsseeder {
+	s := &dnsseeder{
+		name:    "TestNet",
+		dnsHost: "seed.example.com",
+		netSettings: netSettings{
+			ttl:         600,
+			maxSize:     1000,
+			maxAnswers:  100,
+			onionLabel:  "onion",
+			nameServers: []string{"ns1.example.net.", "ns2.example.net."},
+			mbox:        mboxName("admin@example.net", "seed.example.com"),
+			soaMinTTL:   60,
+		},
+	}
+	s.theList = make(map[string]*node)
+	s.counts.DNSCounts = make([]uint32, maxDNSTypes)
+	setTestSeeders(map[string]*dnsseeder{s.name: s}, []string{s.name})
+	config.dnsmtx.Lock()
+	config.dns = make(map[string]*dnsZone)
+	config.dnsmtx.Unlock()
+	for i := 0; i < 100; i++ {
+		addTestNode(s, fmt.Sprintf("2001:db8:%x::1", i), wire.SFNodeNetwork|wire.SFNodeWitness)
+	}
+	s.updateDNS()
+	t.Cleanup(func() {
+		setTestSeeders(nil, nil)
+		config.dnsmtx.Lock()
+		config.dns = nil
+		config.dnsmtx.Unlock()
+	})
+	return s
+}
+
+// setTestSeeders replaces the running seeders. The dns stats are updated in
+// goroutines that may still be reading them from an earlier test
+func setTestSeeders(seeders map[string]*dnsseeder, order []string) {
+	config.smtx.Lock()
+	config.seeders, config.order = seeders, order
+	config.smtx.Unlock()
+}
+
+// addTestNode adds a statusCG node with the services to theList
+func addTestNode(s *dnsseeder, ip string, services wire.ServiceFlag) *node {
+	na := wire.NewNetAddressIPPort(net.ParseIP(ip), 9333, services)
+	s.addNa(na)
+	nd := s.theList[net.JoinHostPort(na.IP.String(), "9333")]
+	nd.status = statusCG
+	nd.services = services
+	return nd
+}
+
+// query sends a query to handleDNS and returns the packed size and the answer
+func query(t *testing.T, client net.Addr, m *dns.Msg) (int, *dns.Msg) {
+	tw := &testWriter{remote: client}
+	handleDNS(tw, m)
+	if tw.msg == nil {
+		t.Fatalf("no answer written for %s", m.Question[0].Name)
+	}
+	b, err := tw.msg.Pack()
+	if err != nil {
+		t.Fatalf("unable to pack answer: %v", err)
+	}
+	return len(b), tw.msg
+}
+
+func TestHandleDNSTruncation(t *testing.T) {
+	setupTestDNS(t)
+
+	m := new(dns.Msg)
+	m.SetQuestion("seed.example.com.", dns.TypeAAAA)
+
+	// plain udp is limited to 512 bytes
+	l, resp := query(t, udpClient, m)
+	if l > dns.MinMsgSize || !resp.Truncated || resp.IsEdns0() != nil {
+		t.Errorf("udp answer not truncated to 512 bytes: len %d tc %v", l, resp.Truncated)
+	}
+
+	// EDNS0 clients get up to the advertised size and an OPT record back
+	m.SetEdns0(4096, false)
+	l, resp = query(t, udpClient, m)
+	if l > ednsUDPSize || !resp.Truncated || resp.IsEdns0() == nil {
+		t.Errorf("edns0 answer not sized correctly: len %d tc %v", l, resp.Truncated)
+	}
+	if len(resp.Answer) <= 15 {
+		t.Errorf("edns0 answer should hold more records than plain udp, got %d", len(resp.Answer))
+	}
+
+	// tcp answers are not truncated
+	_, resp = query(t, tcpClient, m)
+	if resp.Truncated || len(resp.Answer) != 100 {
+		t.Errorf("tcp answer truncated: tc %v records %d", resp.Truncated, len(resp.Answer))
+	}
+
+	// unsupported EDNS versions get BADVERS
+	m = new(dns.Msg)
+	m.SetQuestion("seed.example.com.", dns.TypeAAAA)
+	m.SetEdns0(4096, false)
+	m.IsEdns0().SetVersion(1)
+	_, resp = query(t, udpClient, m)
+	if resp.Rcode != dns.RcodeBadVers {
+		t.Errorf("expected BADVERS, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestHandleDNSZoneRecords(t *testing.T) {
+	s := setupTestDNS(t)
+	s.addZoneRecords(config.dns["seed.example.com."], 1234)
+
+	m := new(dns.Msg)
+	m.SetQuestion("seed.example.com.", dns.TypeSOA)
+	_, resp := query(t, udpClient, m)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("expected one SOA record, got %d", len(resp.Answer))
+	}
+	soa, ok := resp.Answer[0].(*dns.SOA)
+	if !ok || soa.Serial != 1234 || soa.Ns != "ns1.example.net." || soa.Mbox != "admin.example.net." {
+		t.Errorf("unexpected SOA record: %v", resp.Answer[0])
+	}
+
+	m.SetQuestion("seed.example.com.", dns.TypeNS)
+	_, resp = query(t, udpClient, m)
+	if len(resp.Answer) != 2 {
+		t.Errorf("expected two NS records, got %d", len(resp.Answer))
+	}
+
+	// empty answers include the SOA with the negative caching TTL
+	m.SetQuestion("seed.example.com.", dns.TypeA)
+	_, resp = query(t, udpClient, m)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("expected empty answer with SOA authority: %v", resp)
+	}
+	if resp.Ns[0].Header().Ttl != 60 {
+		t.Errorf("negative SOA TTL is %d, expected 60", resp.Ns[0].Header().Ttl)
+	}
+}
+
+func TestHandleDNSRcodes(t *testing.T) {
+	setupTestDNS(t)
+
+	var td = []struct {
+		name  string
+		rcode int
+		soa   bool
+	}{
+		{"seed.example.com.", dns.RcodeSuccess, true},
+		{"SEED.Example.COM.", dns.RcodeSuccess, true},
+		{"x9.seed.example.com.", dns.RcodeSuccess, true},
+		{"0x9.seed.example.com.", dns.RcodeSuccess, true},
+		{"foo.seed.example.com.", dns.RcodeNameError, true},
+		{"a.x9.seed.example.com.", dns.RcodeNameError, true},
+		{"example.com.", dns.RcodeRefused, false},
+		{"seed.example.org.", dns.RcodeRefused, false},
+	}
+
+	for _, tc := range td {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		_, resp := query(t, udpClient, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("%s: expected %s, got %s", tc.name, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+		if hasSOA := len(resp.Ns) == 1; hasSOA != tc.soa {
+			t.Errorf("%s: expected SOA in authority %v, got %v", tc.name, tc.soa, hasSOA)
+		}
+	}
+
+	// requests without a question get FORMERR
+	tw := &testWriter{remote: udpClient}
+	handleDNS(tw, new(dns.Msg))
+	if tw.msg == nil || tw.msg.Rcode != dns.RcodeFormatError {
+		t.Errorf("expected FORMERR for request without a question")
+	}
+}
+
+func TestHandleDNSServiceFlags(t *testing.T) {
+	s := setupTestDNS(t)
+	addTestNode(s, "10.0.0.1", wire.SFNodeNetwork)
+	addTestNode(s, "10.0.0.2", wire.SFNodeNetwork|wire.SFNodeWitness)
+	addTestNode(s, "10.0.0.3", wire.SFNodeNetwork|wire.SFNodeWitness|1<<24)
+	addTestNode(s, "10.0.0.4", wire.SFNodeNetworkLimited|wire.SFNodeWitness|1<<24)
+	s.updateDNS()
+
+	var td = []struct {
+		name  string
+		count int
+	}{
+		{"seed.example.com.", 4},
+		{"x1.seed.example.com.", 3},
+		{"0x1.seed.example.com.", 3},
+		{"x9.seed.example.com.", 2},
+		{"x1000000.seed.example.com.", 2},
+		{"X1000009.seed.example.com.", 1},
+		{"x1000408.seed.example.com.", 1},
+		{"x2.seed.example.com.", 0},
+	}
+
+	for _, tc := range td {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		_, resp := query(t, udpClient, m)
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != tc.count {
+			t.Errorf("%s: expected %d records, got %d (%s)", tc.name, tc.count, len(resp.Answer), dns.RcodeToString[resp.Rcode])
+		}
+		for _, rr := range resp.Answer {
+			if rr.Header().Name != tc.name {
+				t.Errorf("%s: answer has owner name %s", tc.name, rr.Header().Name)
+			}
+		}
+	}
+
+	for _, bad := range []string{"x.seed.example.com.", "xz.seed.example.com.", "00x1.seed.example.com.", "x11111111111111111.seed.example.com."} {
+		m := new(dns.Msg)
+		m.SetQuestion(bad, dns.TypeA)
+		if _, resp := query(t, udpClient, m); resp.Rcode != dns.RcodeNameError {
+			t.Errorf("%s: expected NXDOMAIN, got %s", bad, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+func TestParseQueryName(t *testing.T) {
+	zone := "seed.example.com."
+
+	var td = []struct {
+		name     string
+		ok       bool
+		services wire.ServiceFlag
+		realm    uint64
+		max      int
+	}{
+		{"seed.example.com.", true, 0, realmAll, 0},
+		{"x9.seed.example.com.", true, 9, realmAll, 0},
+		{"n5.x9.seed.example.com.", true, 9, realmAll, 5},
+		{"x9.n5.seed.example.com.", true, 9, realmAll, 5},
+		{"r1.l10.0x1000009.seed.example.com.", true, 0x1000009, realmIPv4, 10},
+		{"r2.seed.example.com.", true, 0, realmIPv6, 0},
+		{"x9.x1.seed.example.com.", false, 0, 0, 0},
+		{"n5.l5.seed.example.com.", false, 0, 0, 0},
+		{"n0.seed.example.com.", false, 0, 0, 0},
+		{"n1000.seed.example.com.", false, 0, 0, 0},
+		{"r0.seed.example.com.", false, 0, 0, 0},
+		{"r8.seed.example.com.", false, 0, 0, 0},
+		{"q1.seed.example.com.", false, 0, 0, 0},
+		{"x9..seed.example.com.", false, 0, 0, 0},
+		{"other.example.com.", false, 0, 0, 0},
+	}
+
+	for _, tc := range td {
+		f, ok := parseQueryName(tc.name, zone, "onion")
+		if ok != tc.ok {
+			t.Errorf("%s: expected ok %v, got %v", tc.name, tc.ok, ok)
+			continue
+		}
+		if ok && (f.services != tc.services || f.realm != tc.realm || f.max != tc.max) {
+			t.Errorf("%s: unexpected filter %+v", tc.name, f)
+		}
+	}
+}
+
+func TestHandleDNSOnion(t *testing.T) {
+	s := setupTestDNS(t)
+	for i := 0; i < 3; i++ {
+		pubKey := make([]byte, wire.TorV3Size)
+		pubKey[0] = byte(i)
+		services := wire.SFNodeNetwork | wire.SFNodeWitness
+		if i == 0 {
+			services |= 1 << 24
+		}
+		na := wire.NetAddressV2FromBytes(time.Now(), services, pubKey, 9333)
+		s.addNaV2(na)
+		nd := s.theList[nodeKey(na)]
+		nd.status = statusCG
+		nd.services = services
+	}
+	s.updateDNS()
+
+	var td = []struct {
+		name  string
+		qtype uint16
+		count int
+	}{
+		{"onion.seed.example.com.", dns.TypeTXT, 3},
+		{"x1000000.onion.seed.example.com.", dns.TypeTXT, 1},
+		{"onion.x9.n2.seed.example.com.", dns.TypeTXT, 2},
+		{"r4.onion.seed.example.com.", dns.TypeTXT, 3},
+		{"r1.onion.seed.example.com.", dns.TypeTXT, 0},
+		{"onion.seed.example.com.", dns.TypeAAAA, 0},
+		{"seed.example.com.", dns.TypeTXT, 0},
+	}
+
+	for _, tc := range td {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		_, resp := query(t, tcpClient, m)
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != tc.count {
+			t.Errorf("%s %s: expected %d records, got %d (%s)", tc.name, dns.TypeToString[tc.qtype], tc.count, len(resp.Answer), dns.RcodeToString[resp.Rcode])
+		}
+		for _, rr := range resp.Answer {
+			if txt, ok := rr.(*dns.TXT); !ok || !strings.HasSuffix(txt.Txt[0], ".onion:9333") {
+				t.Errorf("%s: unexpected record %v", tc.name, rr)
+			}
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("onion.onion.seed.example.com.", dns.TypeTXT)
+	if _, resp := query(t, udpClient, m); resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN for a repeated onion label, got %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	// the json list matches the TXT records
+	rec := httptest.NewRecorder()
+	onionHandler(rec, httptest.NewRequest("GET", "/onions.json?s=TestNet&x=1000000", nil))
+	var seeds []onionSeed
+	if err := json.NewDecoder(rec.Body).Decode(&seeds); err != nil {
+		t.Fatalf("unable to decode onions.json: %v", err)
+	}
+	if len(seeds) != 1 || !strings.HasSuffix(seeds[0].Address, ".onion:9333") || seeds[0].Services != "0000000001000009" {
+		t.Errorf("unexpected onions.json output: %+v", seeds)
+	}
+}
+
+func TestPublishNodesPolicy(t *testing.T) {
+	s := &dnsseeder{name: "TestNet", netSettings: netSettings{maxSize: 100, pool: 2}}
+	s.theList = make(map[string]*node)
+
+	now := time.Now()
+	crawl := func(nd *node, good bool, n int) {
+		for i := 0; i < n; i++ {
+			nd.updateStats(good, now)
+			nd.lastTry = now
+			now = now.Add(time.Hour)
+		}
+	}
+
+	// a node up for a day, one that just came up and one that keeps failing
+	old := addTestNode(s, "1.1.1.1", wire.SFNodeNetwork)
+	old.version = 70016
+	crawl(old, true, 24)
+	young := addTestNode(s, "2.2.2.2", wire.SFNodeNetwork)
+	young.version = 70016
+	crawl(young, true, 1)
+	flaky := addTestNode(s, "3.3.3.3", wire.SFNodeNetwork)
+	flaky.version = 70016
+	crawl(flaky, false, 10)
+
+	published := func() map[*node]bool {
+		pub := make(map[*node]bool)
+		for _, nd := range s.publishNodes() {
+			pub[nd] = true
+		}
+		return pub
+	}
+
+	pub := published()
+	if len(pub) != 2 || !pub[old] || !pub[young] {
+		t.Errorf("expected the reliable nodes to be published, got %v nodes", len(pub))
+	}
+
+	// the most reliable nodes are preferred when the pool is full
+	s.pool = 1
+	if pub = published(); len(pub) != 1 || !pub[old] {
+		t.Errorf("expected only the most reliable node to be published")
+	}
+	s.pool = 0
+
+	// nodes must meet the configured policy
+	s.policy.minSuccess = 5
+	if pub = published(); len(pub) != 1 || !pub[old] {
+		t.Errorf("node with too few successful crawls published")
+	}
+	s.policy = goodPolicy{minVersion: 70017}
+	if pub = published(); len(pub) != 0 {
+		t.Errorf("node with a low protocol version published")
+	}
+	s.policy = goodPolicy{}
+	s.policy.minUptime[stat1D] = 50
+	if pub = published(); len(pub) != 1 || !pub[old] {
+		t.Errorf("node with a low 1 day uptime published")
+	}
+}
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"html"
+	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
-	"text/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+	"github.com/miekg/dns"
 )
 
-// startHTTP runs in a goroutine and provides the web interface
-// to the dnsseeder
-func startHTTP(port string) {
+// startHTTP starts the web interface to the dnsseeder in a goroutine and
+// returns the server so it can be shut down. addr is a host:port or unix:path
+func startHTTP(addr string) *http.Server {
 
 	http.HandleFunc("/dns", dnsWebHandler)
 	http.HandleFunc("/node", nodeHandler)
@@ -19,18 +29,96 @@ func startHTTP(port string) {
 	http.HandleFunc("/statusCG", statusCGHandler)
 	http.HandleFunc("/statusWG", statusWGHandler)
 	http.HandleFunc("/statusNG", statusNGHandler)
+	http.HandleFunc("/statusWC", statusWCHandler)
 	http.HandleFunc("/summary", summaryHandler)
 	http.HandleFunc("/seeds.txt", txtHandler)
+	http.HandleFunc("/onions.json", onionHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 	http.HandleFunc("/", emptyHandler)
-	// listen only on localhost
-	err := http.ListenAndServe("127.0.0.1:"+port, nil)
+	registerAPI(http.DefaultServeMux)
+	// the admin api is only served when admins have to log in
+	if config.webAuth.hasAdmins() {
+		registerAdmin(http.DefaultServeMux)
+		if config.datadir == "" {
+			log.Printf("warning - web admins can not add bans without -datadir to save them\n")
+		}
+	}
+
+	srv := &http.Server{Handler: config.webAuth.handler(http.DefaultServeMux)}
+	if config.httpCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.httpCert, config.httpKey)
+		if err != nil {
+			log.Fatal("unable to load web tls certificate: ", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	ln, err := listenWeb(addr)
+	if err != nil {
+		log.Fatal("unable to listen for web requests: ", err)
+	}
+	if config.webAuth == nil && !isLoopback(addr) {
+		log.Printf("warning - web interface on %s has no authentication. Use -w-htpasswd or -w-tokens\n", addr)
+	}
+
+	go func() {
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+	return srv
+}
+
+// webAddr returns the address for the web server to listen on. The -w port
+// listens only on localhost
+func webAddr(port, addr string) string {
+	if addr == "" && port != "" {
+		return "127.0.0.1:" + port
+	}
+	return addr
+}
+
+// listenWeb listens on a tcp host:port or a unix:path socket
+func listenWeb(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// remove the socket left by a previous run
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// isLoopback returns true if the web address can only be reached from this host
+func isLoopback(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		return false
 	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
 
+// dnsRecords holds the published records of one type for the dns page
+type dnsRecords struct {
+	Name    string
+	Records []string
 }
 
-// dnsWebHandler processes all requests and returns output in the requested format
+// dnsWebHandler displays the records currently served for a seeder
 func dnsWebHandler(w http.ResponseWriter, r *http.Request) {
 
 	st := time.Now()
@@ -40,97 +128,26 @@ func dnsWebHandler(w http.ResponseWriter, r *http.Request) {
 	s := getSeederByName(n)
 	if s == nil {
 		writeHeader(w, r)
-		fmt.Fprintf(w, "No seeder found: %s", html.EscapeString(n))
+		execTemplate(w, "message", "No seeder found: "+n)
 		writeFooter(w, r, st)
 		return
 	}
 
-	// FIXME - This is ugly code and needs to be cleaned up a lot
-
-	config.dnsmtx.RLock()
-	// if the dns map does not have a key for the request it will return an empty slice
-	v4std := config.dns[s.dnsHost+".A"]
-	v6std := config.dns[s.dnsHost+".AAAA"]
-	config.dnsmtx.RUnlock()
-
-	var v4stdstr []string
-	var v6stdstr []string
-
-	if x := len(v4std); x > 0 {
-		v4stdstr = make([]string, x)
-		for k, v := range v4std {
-			v4stdstr[k] = v.String()
-		}
-	} else {
-		v4stdstr = []string{"No records Available"}
-	}
-
-	// ipv6
-	if x := len(v6std); x > 0 {
-		v6stdstr = make([]string, x)
-		for k, v := range v6std {
-			v6stdstr[k] = v.String()
+	// if the dns map does not have the zone for the request it will return an empty slice
+	var recs []dnsRecords
+	for _, t := range []struct {
+		name  string
+		qtype uint16
+	}{{"IPv4", dns.TypeA}, {"IPv6", dns.TypeAAAA}} {
+		dr := dnsRecords{Name: t.name}
+		for _, rr := range nodeRecords(dns.Fqdn(s.dnsHost), t.qtype, 0) {
+			dr.Records = append(dr.Records, rr.String())
 		}
-	} else {
-		v6stdstr = []string{"No records Available"}
+		recs = append(recs, dr)
 	}
 
-	t1 := `
-	<center>
-	<table border=1>
-	  <tr>
-	  <th>Standard Ports</th>
-	  </tr>
-	  <tr>
-	  <td>
-	  `
-	t2 := `  {{range .}}
-	       {{.}}<br>
-	     {{end}}
-		 `
-	t3 := `
-	  </td>
-	  <td>
-	  `
-	t4 := `
-	  </td>
-	  </tr>
-	</table>
-	</center>
-	`
-
 	writeHeader(w, r)
-	fmt.Fprintf(w, "<b>Currently serving the following DNS records</b>")
-	fmt.Fprintf(w, "<p><center><b>IPv4</b></center></p>")
-	fmt.Fprintf(w, t1)
-
-	t := template.New("v4 template")
-	t, err := t.Parse(t2)
-	if err != nil {
-		log.Printf("error parsing template v4 %v\n", err)
-	}
-	err = t.Execute(w, v4stdstr)
-	if err != nil {
-		log.Printf("error executing template v4 %v\n", err)
-	}
-
-	fmt.Fprintf(w, t3)
-
-	fmt.Fprintf(w, t4)
-
-	// ipv6 records
-
-	fmt.Fprintf(w, "<p><center><b>IPv6</b></center></p>")
-	fmt.Fprintf(w, t1)
-
-	err = t.Execute(w, v6stdstr)
-	if err != nil {
-		log.Printf("error executing template v6 %v\n", err)
-	}
-
-	fmt.Fprintf(w, t3)
-
-	fmt.Fprintf(w, t4)
+	execTemplate(w, "dns", recs)
 	writeFooter(w, r, st)
 }
 
@@ -153,13 +170,31 @@ func statusWGHandler(w http.ResponseWriter, r *http.Request) {
 func statusNGHandler(w http.ResponseWriter, r *http.Request) {
 	statusHandler(w, r, statusNG)
 }
+func statusWCHandler(w http.ResponseWriter, r *http.Request) {
+	statusHandler(w, r, statusWC)
+}
+
+// statusDesc describes each node status on the status pages
+var statusDesc = [maxStatusTypes]string{
+	statusRG: "(Reported Good) Have not been able to get addresses yet",
+	statusCG: "(Currently Good) Able to connect and get addresses",
+	statusWG: "(Was Good) Was Ok but now can not get addresses",
+	statusNG: "(No Good) Unable to get addresses",
+	statusWC: "(Wrong Chain) Failed the checkpoint headers check",
+}
 
 type webstatus struct {
 	Key    string
-	Value  string
+	Fields []webField
 	Seeder string
 }
 
+// webField is one named value in the node summary on the status pages
+type webField struct {
+	Name  string
+	Value string
+}
+
 func statusHandler(w http.ResponseWriter, r *http.Request, status uint32) {
 
 	startT := time.Now()
@@ -169,63 +204,48 @@ func statusHandler(w http.ResponseWriter, r *http.Request, status uint32) {
 	s := getSeederByName(n)
 	if s == nil {
 		writeHeader(w, r)
-		fmt.Fprintf(w, "No seeder found called %s", html.EscapeString(n))
+		execTemplate(w, "message", "No seeder found called "+n)
 		writeFooter(w, r, startT)
 		return
 	}
 
 	// gather all the info before writing anything to the remote browser
 	ws := generateWebStatus(s, status)
-
-	st := `
-	<center>
-	<table border=1>
-	  <tr>
-	  <th>Node</th>
-	  <th>Summary</th>
-	  </tr>
-	     {{range .}}
-	  <tr>
-	  <td>
-	       <a href="/node?s={{.Seeder}}&nd={{.Key}}">{{.Key}}</a>
-	  </td>
-	  <td>
-	       {{.Value}}
-	  </td>
-	  </tr>
-	     {{end}}
-	</table>
-	</center>
-	`
+	bans := generateWebBans(s)
 
 	writeHeader(w, r)
+	execTemplate(w, "status", struct {
+		Status string
+		Desc   string
+		Nodes  []webstatus
+		Bans   []webBan
+	}{status2str(status), statusDesc[status], ws, bans})
+	writeFooter(w, r, startT)
+}
 
-	if len(ws) == 0 {
-		fmt.Fprintf(w, "No Nodes found with this status")
-	} else {
+// webBan is one active ban on the status pages
+type webBan struct {
+	Target  string
+	Reason  string
+	By      string
+	Expires string
+	Hits    uint64
+}
 
-		switch status {
-		case statusRG:
-			fmt.Fprintf(w, "<center><b>Node Status: statusRG - (Reported Good) Have not been able to get addresses yet</b></center>")
-		case statusCG:
-			fmt.Fprintf(w, "<center><b>Node Status: statusCG - (Currently Good) Able to connect and get addresses</b></center>")
-		case statusWG:
-			fmt.Fprintf(w, "<center><b>Node Status: statusWG - (Was Good) Was Ok but now can not get addresses</b></center>")
-		case statusNG:
-			fmt.Fprintf(w, "<center><b>Node Status: statusNG - (No Good) Unable to get addresses</b></center>")
-		}
-		t := template.New("Status template")
-		t, err := t.Parse(st)
-		if err != nil {
-			log.Printf("error parsing status template %v\n", err)
-		}
-		err = t.Execute(w, ws)
-		if err != nil {
-			log.Printf("error executing status template %v\n", err)
-		}
+// generateWebBans returns the active bans of the seeder with the most hits first
+func generateWebBans(s *dnsseeder) []webBan {
+	bans := s.activeBans()
+	sort.SliceStable(bans, func(i, j int) bool { return bans[i].Hits > bans[j].Hits })
 
+	var wb []webBan
+	for _, b := range bans {
+		expires := "never"
+		if b.Expires != nil {
+			expires = b.Expires.Format(time.RFC3339)
+		}
+		wb = append(wb, webBan{Target: b.Target, Reason: b.Reason, By: b.By, Expires: expires, Hits: b.Hits})
 	}
-	writeFooter(w, r, startT)
+	return wb
 }
 
 // generateWebStatus is given a node status and returns a slice of webstatus structures
@@ -235,48 +255,51 @@ func generateWebStatus(s *dnsseeder, status uint32) (ws []webstatus) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 
-	var valueStr string
-
 	for k, v := range s.theList {
 		if v.status != status {
 			continue
 		}
 
+		var fields []webField
 		switch status {
 		case statusRG:
-			valueStr = fmt.Sprintf("<b>Fail Count:</b> %v <b>DNS Type:</b> %s",
-				v.connectFails,
-				v.dns2str())
+			fields = []webField{
+				{"Fail Count", fmt.Sprint(v.connectFails)},
+				{"DNS Type", v.dns2str()},
+			}
 		case statusCG:
-			valueStr = fmt.Sprintf("<b>Remote Version:</b> %v%s <b>Last Block:</b> %v <b>DNS Type:</b> %s",
-				v.version,
-				v.strVersion,
-				v.lastBlock,
-				v.dns2str())
-
+			fields = []webField{
+				{"Remote Version", fmt.Sprintf("%v%s", v.version, v.strVersion)},
+				{"Last Block", fmt.Sprint(v.lastBlock)},
+				{"DNS Type", v.dns2str()},
+			}
 		case statusWG:
-			valueStr = fmt.Sprintf("<b>Last Try:</b> %s ago <b>Last Status:</b> %s\n",
-				time.Since(v.lastTry).String(),
-				v.statusStr)
-
+			fields = []webField{
+				{"Last Try", time.Since(v.lastTry).String() + " ago"},
+				{"Last Status", v.statusStr},
+			}
 		case statusNG:
-			valueStr = fmt.Sprintf("<b>Fail Count:</b> %v <b>Last Try:</b> %s ago <b>Last Status:</b> %s\n",
-				v.connectFails,
-				time.Since(v.lastTry).String(),
-				v.statusStr)
-
-		default:
-			valueStr = ""
+			fields = []webField{
+				{"Fail Count", fmt.Sprint(v.connectFails)},
+				{"Last Try", time.Since(v.lastTry).String() + " ago"},
+				{"Last Status", v.statusStr},
+			}
+		case statusWC:
+			fields = []webField{
+				{"Remote Version", fmt.Sprintf("%v%s", v.version, v.strVersion)},
+				{"Last Block", fmt.Sprint(v.lastBlock)},
+				{"Last Status", v.statusStr},
+			}
 		}
 
-		ows := webstatus{
+		ws = append(ws, webstatus{
 			Key:    k,
-			Value:  valueStr,
+			Fields: fields,
 			Seeder: s.name,
-		}
-		ws = append(ws, ows)
+		})
 	}
 
+	sort.Slice(ws, func(i, j int) bool { return ws[i].Key < ws[j].Key })
 	return ws
 }
 
@@ -300,6 +323,16 @@ type webtemplate struct {
 	Strversion     string
 	Services       string
 	Lastblock      int32
+	Success        uint32
+	Total          uint32
+	Uptime         []uptimeStat
+}
+
+// uptimeStat is one uptime window shown on the node page
+type uptimeStat struct {
+	Window string
+	Uptime string
+	Count  string
 }
 
 // nodeHandler displays details about one node
@@ -307,34 +340,12 @@ func nodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	st := time.Now()
 
-	ndt := `
-    <center>
-    <table border=1>
-      <tr>
-      <th>Node {{.Key}}</th><th>Details</th>
-      </tr>
-      <tr><td>IP Address</td><td>{{.IP}}</td></tr>
-      <tr><td>Port</td><td>{{.Port}}</td></tr>
-      <tr><td>DNS Type</td><td>{{.Dnstype}}</td></tr>
-      <tr><td>Last Connect</td><td>{{.Lastconnect}}<br>{{.Lastconnectago}} ago</td></tr>
-      <tr><td>Last Connect Status</td><td>{{.Statusstr}}</td></tr>
-      <tr><td>Last Try</td><td>{{.Lasttry}}<br>{{.Lasttryago}} ago</td></tr>
-      <tr><td>Crawl Start</td><td>{{.Crawlstart}}<br>{{.Crawlstartago}} ago</td></tr>
-      <tr><td>Crawl Active</td><td>{{.Crawlactive}}</td></tr>
-      <tr><td>Connection Fails</td><td>{{.Connectfails}}</td></tr>
-      <tr><td>Remote Version</td><td>{{.Version}}</td></tr>
-      <tr><td>Remote SubVersion</td><td>{{.Strversion}}</td></tr>
-      <tr><td>Remote Services</td><td>{{.Services}}</td></tr>
-      <tr><td>Remote Last Block</td><td>{{.Lastblock}}</td></tr>
-    </table>
-    </center>
-    `
 	// read the seeder name
 	n := r.FormValue("s")
 	s := getSeederByName(n)
 	if s == nil {
 		writeHeader(w, r)
-		fmt.Fprintf(w, "No seeder found called %s", html.EscapeString(n))
+		execTemplate(w, "message", "No seeder found called "+n)
 		writeFooter(w, r, st)
 		return
 	}
@@ -345,12 +356,13 @@ func nodeHandler(w http.ResponseWriter, r *http.Request) {
 	k := r.FormValue("nd")
 	writeHeader(w, r)
 	if _, ok := s.theList[k]; !ok {
-		fmt.Fprintf(w, "Sorry there is no Node with those details\n")
+		execTemplate(w, "message", "Sorry there is no Node with those details")
 	} else {
 
 		nd := s.theList[k]
 		wt := webtemplate{
-			IP:             nd.na.IP.String(),
+			Key:            k,
+			IP:             nd.na.Addr.String(),
 			Port:           nd.na.Port,
 			Dnstype:        nd.dns2str(),
 			Statusstr:      nd.statusStr,
@@ -366,51 +378,69 @@ func nodeHandler(w http.ResponseWriter, r *http.Request) {
 			Strversion:     nd.strVersion,
 			Services:       nd.services.String(),
 			Lastblock:      nd.lastBlock,
+			Success:        nd.success,
+			Total:          nd.total,
 		}
-
-		// display details for the Node
-		t := template.New("Node template")
-		t, err := t.Parse(ndt)
-		if err != nil {
-			log.Printf("error parsing Node template %v\n", err)
-		}
-		err = t.Execute(w, wt)
-		if err != nil {
-			log.Printf("error executing Node template %v\n", err)
+		for i, d := range statWindows {
+			wt.Uptime = append(wt.Uptime, uptimeStat{
+				Window: d.String(),
+				Uptime: fmt.Sprintf("%.2f%%", nd.uptime(i)),
+				Count:  fmt.Sprintf("%.1f", nd.stats[i].count),
+			})
 		}
 
+		// display details for the Node
+		execTemplate(w, "node", wt)
 	}
 	writeFooter(w, r, st)
 }
 
-// summaryHandler displays details about one node
+// statusTuning holds the crawl tuning for one node status on the summary page
+type statusTuning struct {
+	Status   string
+	MaxStart uint32
+	Delay    time.Duration
+}
+
+// seederSummary holds the stats for one seeder on the summary page
+type seederSummary struct {
+	Name     string
+	RG       uint32
+	RGS      uint32
+	CG       uint32
+	CGS      uint32
+	WG       uint32
+	WGS      uint32
+	NG       uint32
+	NGS      uint32
+	WC       uint32
+	WCS      uint32
+	Total    uint32
+	V4Std    uint32
+	V6Std    uint32
+	DNSTotal uint32
+	Tip      int32
+	Lagging  uint32
+	MaxLag   int32
+	MaxSize  int
+	Crawl    time.Duration
+	Audit    time.Duration
+	DNS      time.Duration
+	MaxFails uint32
+	Tuning   []statusTuning
+}
+
+// summaryHandler displays the stats for all the seeders
 func summaryHandler(w http.ResponseWriter, r *http.Request) {
 
 	st := time.Now()
 
-	var hc struct {
-		Name     string
-		RG       uint32
-		RGS      uint32
-		CG       uint32
-		CGS      uint32
-		WG       uint32
-		WGS      uint32
-		NG       uint32
-		NGS      uint32
-		Total    uint32
-		V4Std    uint32
-		V6Std    uint32
-		DNSTotal uint32
-	}
-
-	writeHeader(w, r)
 	// loop through each of the seeder name from a slice so they are always returned in
 	// the same order then get a pointer to the seeder struct
-	for _, n := range config.order {
-		s := config.seeders[n]
+	var summaries []seederSummary
+	for _, s := range activeSeeders() {
 
-		hc.Name = s.name
+		hc := seederSummary{Name: s.name}
 		// fill the structs so they can be displayed via the template
 		s.counts.mtx.RLock()
 		hc.RG = s.counts.NdStatus[statusRG]
@@ -421,48 +451,38 @@ func summaryHandler(w http.ResponseWriter, r *http.Request) {
 		hc.WGS = s.counts.NdStarts[statusWG]
 		hc.NG = s.counts.NdStatus[statusNG]
 		hc.NGS = s.counts.NdStarts[statusNG]
-		hc.Total = hc.RG + hc.CG + hc.WG + hc.NG
+		hc.WC = s.counts.NdStatus[statusWC]
+		hc.WCS = s.counts.NdStarts[statusWC]
+		hc.Total = hc.RG + hc.CG + hc.WG + hc.NG + hc.WC
 
 		hc.V4Std = s.counts.DNSCounts[dnsV4Std]
 		hc.V6Std = s.counts.DNSCounts[dnsV6Std]
 		hc.DNSTotal = hc.V4Std + hc.V6Std
+		hc.Tip = s.counts.TipHeight
+		hc.Lagging = s.counts.Lagging
 		s.counts.mtx.RUnlock()
 
-		// we are using basic and simple html here. No fancy graphics or css
-		sp := `
-    <b>Stats for seeder: {{.Name}}</b>
-    <center>
-    <table><tr><td>
-    Node Stats (count/started)<br>
-    <table border=1><tr>
-	<td><a href="/statusRG?s={{.Name}}">RG: {{.RG}}/{{.RGS}}</a></td>
-    <td><a href="/statusCG?s={{.Name}}">CG: {{.CG}}/{{.CGS}}</a></td>
-    <td><a href="/statusWG?s={{.Name}}">WG: {{.WG}}/{{.WGS}}</a></td>
-    <td><a href="/statusNG?s={{.Name}}">NG: {{.NG}}/{{.NGS}}</a></td>
-    <td>Total: {{.Total}}</td>
-    <td><a title="Export in format consumed by Bitcoin Core contrib/seeds" href="/seeds.txt?s={{.Name}}">seeds.txt</a></td>
-    </tr></table>
-    </td><td>
-    DNS Requests<br>
-    <table border=1><tr>
-	<td>V4 Std: {{.V4Std}}</td>
-    <td>V6 Std: {{.V6Std}}</td>
-    <td><a href="/dns?s={{.Name}}">Total: {{.DNSTotal}}</a></td>
-    </tr></table>
-    </td></tr></table>
-	</center>
-	`
-		t := template.New("Header template")
-		t, err := t.Parse(sp)
-		if err != nil {
-			log.Printf("error parsing summary template %v\n", err)
+		s.mtx.RLock()
+		hc.MaxLag = s.maxBlockLag
+		hc.MaxSize = s.maxSize
+		hc.Crawl = s.crawlDelay
+		hc.Audit = s.auditDelay
+		hc.DNS = s.dnsDelay
+		hc.MaxFails = s.maxFails
+		for st := uint32(0); st < maxStatusTypes; st++ {
+			hc.Tuning = append(hc.Tuning, statusTuning{
+				Status:   status2str(st),
+				MaxStart: s.maxStart[st],
+				Delay:    time.Duration(s.delay[st]) * time.Second,
+			})
 		}
+		s.mtx.RUnlock()
 
-		err = t.Execute(w, hc)
-		if err != nil {
-			log.Printf("error executing summary template %v\n", err)
-		}
+		summaries = append(summaries, hc)
 	}
+
+	writeHeader(w, r)
+	execTemplate(w, "summary", summaries)
 	writeFooter(w, r, st)
 }
 
@@ -489,7 +509,7 @@ func txtHandler(w http.ResponseWriter, r *http.Request) {
 		address := k
 
 		var good int
-		if v.status == statusCG {
+		if s.goodNode(v) {
 			good = 1
 		} else {
 			good = 0
@@ -497,9 +517,6 @@ func txtHandler(w http.ResponseWriter, r *http.Request) {
 
 		lastSuccess := v.lastConnect
 
-		// Alas we don't actually measure this, so fake it.
-		uptime := (100.0-float32(v.rating))/2.0 + 50.0
-
 		blocks := v.lastBlock
 
 		services := v.services
@@ -508,30 +525,77 @@ func txtHandler(w http.ResponseWriter, r *http.Request) {
 
 		userAgent := v.strVersion
 
-		fmt.Fprintf(w, "%s                                  %d   %d  %.2f%% %.2f%% %.2f%% %.2f%% %.2f%%  %d  %08x  %d %q\n", address, good, lastSuccess.Unix(), uptime, uptime, uptime, uptime, uptime, blocks, int32(services), version, userAgent)
+		fmt.Fprintf(w, "%s                                  %d   %d  %.2f%% %.2f%% %.2f%% %.2f%% %.2f%%  %d  %08x  %d %q\n", address, good, lastSuccess.Unix(), v.uptime(stat2H), v.uptime(stat8H), v.uptime(stat1D), v.uptime(stat1W), v.uptime(stat1M), blocks, int32(services), version, userAgent)
+	}
+}
+
+// onionSeed is one tor node in the onions.json output
+type onionSeed struct {
+	Address  string `json:"address"`
+	Services string `json:"services"`
+}
+
+// onionHandler outputs the published tor nodes as json. The optional x parameter
+// holds the hex service flags the nodes must support, e.g. /onions.json?s=name&x=9
+func onionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// read the seeder name
+	n := r.FormValue("s")
+	s := getSeederByName(n)
+	if s == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "No seeder found called " + n})
+		return
+	}
+
+	var mask wire.ServiceFlag
+	if x := r.FormValue("x"); x != "" {
+		v, err := strconv.ParseUint(strings.TrimPrefix(x, "0x"), 16, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid service flags " + x})
+			return
+		}
+		mask = wire.ServiceFlag(v)
+	}
+
+	// use the published records so the list matches the TXT records served in dns
+	seeds := []onionSeed{}
+	config.dnsmtx.RLock()
+	if z, ok := config.dns[dns.Fqdn(s.dnsHost)]; ok {
+		for svc, rrs := range z.nodes[dns.TypeTXT] {
+			if svc&mask != mask {
+				continue
+			}
+			for _, rr := range rrs {
+				seeds = append(seeds, onionSeed{
+					Address:  strings.Join(rr.(*dns.TXT).Txt, ""),
+					Services: fmt.Sprintf("%016x", uint64(svc)),
+				})
+			}
+		}
+	}
+	config.dnsmtx.RUnlock()
+
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].Address < seeds[j].Address })
+	if err := json.NewEncoder(w).Encode(seeds); err != nil {
+		log.Printf("error encoding onion seeds %v\n", err)
 	}
 }
 
 // writeHeader will output the standard header
 func writeHeader(w http.ResponseWriter, r *http.Request) {
-	// we are using basic and simple html here. No fancy graphics or css
-	h1 := `
-    <!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
-    <html><head><title>dnsseeder</title></head><body>
-	<center>
-	<a href="/summary">Summary</a>   
-`
-	fmt.Fprintf(w, h1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// read the seeder name
-	n := r.FormValue("s")
-	if n != "" {
-		s := getSeederByName(n)
-		if s != nil {
-			fmt.Fprintf(w, "<br><b>Seeder: %s</b>", html.EscapeString(s.name))
+	var name string
+	if n := r.FormValue("s"); n != "" {
+		if s := getSeederByName(n); s != nil {
+			name = s.name
 		}
 	}
-	fmt.Fprintf(w, "</center><hr><br>")
+	execTemplate(w, "header", name)
 }
 
 // writeFooter will output the standard footer
@@ -544,28 +608,10 @@ func writeFooter(w http.ResponseWriter, r *http.Request, st time.Time) {
 		Rt      string
 	}
 
-	f := `
-	<hr>
-	<center>
-	<b>Version:</b> {{.Version}}
-	<b>Uptime:</b> {{.Uptime}}
-	<b>Request Time:</b> {{.Rt}}
-	</center>
-	</body></html>
-	`
 	Footer.Uptime = time.Since(config.uptime).String()
 	Footer.Version = config.version
 	Footer.Rt = time.Since(st).String()
-
-	t := template.New("Footer template")
-	t, err := t.Parse(f)
-	if err != nil {
-		log.Printf("error parsing template %v\n", err)
-	}
-	err = t.Execute(w, Footer)
-	if err != nil {
-		log.Printf("error executing template %v\n", err)
-	}
+	execTemplate(w, "footer", Footer)
 
 	if config.verbose {
 		log.Printf("status - processed web request: %s %s\n",
@@ -573,3 +619,173 @@ func writeFooter(w http.ResponseWriter, r *http.Request, st time.Time) {
 			r.RequestURI)
 	}
 }
+
+// execTemplate writes one of the web templates
+func execTemplate(w io.Writer, name string, data interface{}) {
+	if err := webTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("error executing %s template %v\n", name, err)
+	}
+}
+
+// webTemplates holds the templates for all the html pages. They are parsed once at
+// startup & html/template escapes the data from remote nodes when they are executed.
+// we are using basic and simple html here. No fancy graphics or css
+var webTemplates = template.Must(template.New("web").Parse(`
+{{define "header"}}
+    <!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
+    <html><head><title>dnsseeder</title></head><body>
+	<center>
+	<a href="/summary">Summary</a>
+	{{if .}}<br><b>Seeder: {{.}}</b>{{end}}
+	</center><hr><br>
+{{end}}
+
+{{define "footer"}}
+	<hr>
+	<center>
+	<b>Version:</b> {{.Version}}
+	<b>Uptime:</b> {{.Uptime}}
+	<b>Request Time:</b> {{.Rt}}
+	</center>
+	</body></html>
+{{end}}
+
+{{define "message"}}{{.}}{{end}}
+
+{{define "dns"}}
+	<b>Currently serving the following DNS records</b>
+	{{range .}}
+	<p><center><b>{{.Name}}</b></center></p>
+	<center>
+	<table border=1>
+	  <tr>
+	  <th>Standard Ports</th>
+	  </tr>
+	  <tr>
+	  <td>
+	  {{range .Records}}
+	       {{.}}<br>
+	  {{else}}
+	       No records Available
+	  {{end}}
+	  </td>
+	  </tr>
+	</table>
+	</center>
+	{{end}}
+{{end}}
+
+{{define "status"}}
+	{{if .Nodes}}
+	<center><b>Node Status: {{.Status}} - {{.Desc}}</b></center>
+	<center>
+	<table border=1>
+	  <tr>
+	  <th>Node</th>
+	  <th>Summary</th>
+	  </tr>
+	     {{range .Nodes}}
+	  <tr>
+	  <td>
+	       <a href="/node?s={{.Seeder}}&nd={{.Key}}">{{.Key}}</a>
+	  </td>
+	  <td>
+	       {{range .Fields}}<b>{{.Name}}:</b> {{.Value}} {{end}}
+	  </td>
+	  </tr>
+	     {{end}}
+	</table>
+	</center>
+	{{else}}
+	No Nodes found with this status
+	{{end}}
+	{{if .Bans}}
+	<br><center><b>Bans</b></center>
+	<center>
+	<table border=1>
+	  <tr>
+	  <th>Target</th><th>Reason</th><th>Added By</th><th>Expires</th><th>Hits</th>
+	  </tr>
+	     {{range .Bans}}
+	  <tr>
+	  <td>{{.Target}}</td><td>{{.Reason}}</td><td>{{.By}}</td><td>{{.Expires}}</td><td>{{.Hits}}</td>
+	  </tr>
+	     {{end}}
+	</table>
+	</center>
+	{{end}}
+{{end}}
+
+{{define "node"}}
+    <center>
+    <table border=1>
+      <tr>
+      <th>Node {{.Key}}</th><th>Details</th>
+      </tr>
+      <tr><td>IP Address</td><td>{{.IP}}</td></tr>
+      <tr><td>Port</td><td>{{.Port}}</td></tr>
+      <tr><td>DNS Type</td><td>{{.Dnstype}}</td></tr>
+      <tr><td>Last Connect</td><td>{{.Lastconnect}}<br>{{.Lastconnectago}} ago</td></tr>
+      <tr><td>Last Connect Status</td><td>{{.Statusstr}}</td></tr>
+      <tr><td>Last Try</td><td>{{.Lasttry}}<br>{{.Lasttryago}} ago</td></tr>
+      <tr><td>Crawl Start</td><td>{{.Crawlstart}}<br>{{.Crawlstartago}} ago</td></tr>
+      <tr><td>Crawl Active</td><td>{{.Crawlactive}}</td></tr>
+      <tr><td>Connection Fails</td><td>{{.Connectfails}}</td></tr>
+      <tr><td>Remote Version</td><td>{{.Version}}</td></tr>
+      <tr><td>Remote SubVersion</td><td>{{.Strversion}}</td></tr>
+      <tr><td>Remote Services</td><td>{{.Services}}</td></tr>
+      <tr><td>Remote Last Block</td><td>{{.Lastblock}}</td></tr>
+      <tr><td>Successful Crawls</td><td>{{.Success}} of {{.Total}}</td></tr>
+      {{range .Uptime}}
+      <tr><td>Uptime ({{.Window}})</td><td>{{.Uptime}} over {{.Count}} crawls</td></tr>
+      {{end}}
+    </table>
+    </center>
+{{end}}
+
+{{define "summary"}}
+    {{range .}}
+    <b>Stats for seeder: {{.Name}}</b>
+    <center>
+    <table><tr><td>
+    Node Stats (count/started)<br>
+    <table border=1><tr>
+	<td><a href="/statusRG?s={{.Name}}">RG: {{.RG}}/{{.RGS}}</a></td>
+    <td><a href="/statusCG?s={{.Name}}">CG: {{.CG}}/{{.CGS}}</a></td>
+    <td><a href="/statusWG?s={{.Name}}">WG: {{.WG}}/{{.WGS}}</a></td>
+    <td><a href="/statusNG?s={{.Name}}">NG: {{.NG}}/{{.NGS}}</a></td>
+    <td><a href="/statusWC?s={{.Name}}">WC: {{.WC}}/{{.WCS}}</a></td>
+    <td>Total: {{.Total}}</td>
+    <td><a title="Export in format consumed by Bitcoin Core contrib/seeds" href="/seeds.txt?s={{.Name}}">seeds.txt</a></td>
+    <td><a title="Published tor onion nodes" href="/onions.json?s={{.Name}}">onions.json</a></td>
+    </tr></table>
+    </td><td>
+    DNS Requests<br>
+    <table border=1><tr>
+	<td>V4 Std: {{.V4Std}}</td>
+    <td>V6 Std: {{.V6Std}}</td>
+    <td><a href="/dns?s={{.Name}}">Total: {{.DNSTotal}}</a></td>
+    </tr></table>
+    </td><td>
+    Chain<br>
+    <table border=1><tr>
+	<td>Consensus Height: {{.Tip}}</td>
+    <td>Lagging CG: {{.Lagging}}{{if .MaxLag}} (over {{.MaxLag}} blocks){{else}} (disabled){{end}}</td>
+    </tr></table>
+    </td></tr></table>
+    <table><tr><td>
+    Crawl Tuning<br>
+    <table border=1><tr>
+    <td>Max Size: {{.MaxSize}}</td>
+    <td>Crawl: {{.Crawl}}</td>
+    <td>Audit: {{.Audit}}</td>
+    <td>DNS: {{.DNS}}</td>
+    <td>Max Fails: {{.MaxFails}}</td>
+    </tr><tr>
+    {{range .Tuning}}<td>{{.Status}} start/delay: {{.MaxStart}}/{{.Delay}}</td>{{end}}
+    </tr></table>
+    </td></tr></table>
+	</center>
+    {{end}}
+{{end}}
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebEscapesNodeData(t *testing.T) {
+	s := setupTestDNS(t)
+	s.counts.NdStatus = make([]uint32, maxStatusTypes)
+	s.counts.NdStarts = make([]uint32, maxStatusTypes)
+	s.maxStart = make([]uint32, maxStatusTypes)
+	s.delay = make([]int64, maxStatusTypes)
+
+	const (
+		agent  = `/Satoshi:0.21/<script>alert("ua")</script>/`
+		status = `"><img src=x onerror=alert(1)>`
+	)
+	var cg, wc string
+	for k, nd := range s.theList {
+		if cg == "" {
+			cg = k
+			nd.strVersion = agent
+			continue
+		}
+		wc = k
+		nd.status = statusWC
+		nd.strVersion = agent
+		nd.statusStr = "wrong chain: " + status
+		break
+	}
+
+	for _, path := range []string{
+		"/node?s=TestNet&nd=" + url.QueryEscape(cg),
+		"/node?s=TestNet&nd=" + url.QueryEscape(wc),
+		"/statusCG?s=TestNet",
+		"/statusWC?s=TestNet",
+		"/summary?s=" + url.QueryEscape("<b>TestNet"),
+		"/node?s=" + url.QueryEscape("<script>x</script>"),
+	} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		switch {
+		case strings.HasPrefix(path, "/node"):
+			nodeHandler(rec, r)
+		case strings.HasPrefix(path, "/statusCG"):
+			statusCGHandler(rec, r)
+		case strings.HasPrefix(path, "/statusWC"):
+			statusWCHandler(rec, r)
+		default:
+			summaryHandler(rec, r)
+		}
+
+		body := rec.Body.String()
+		if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("%s: content type %q", path, ct)
+		}
+		for _, bad := range []string{"<script>", "<img", "<b>TestNet"} {
+			if strings.Contains(body, bad) {
+				t.Errorf("%s: unescaped %q in page", path, bad)
+			}
+		}
+	}
+
+	// the escaped values are still shown
+	rec := httptest.NewRecorder()
+	statusWCHandler(rec, httptest.NewRequest(http.MethodGet, "/statusWC?s=TestNet", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "&lt;script&gt;") || !strings.Contains(body, "&lt;img src=x") {
+		t.Errorf("escaped node data missing from status page:\n%s", body)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -17,30 +19,41 @@ import (
 
 // NodeCounts holds various statistics about the running system for use in html templates
 type NodeCounts struct {
-	NdStatus  []uint32     // number of nodes at each of the 4 statuses - RG, CG, WG, NG
+	NdStatus  []uint32     // number of nodes at each of the 5 statuses - RG, CG, WG, NG, WC
 	NdStarts  []uint32     // number of crawles started last startcrawlers run
 	DNSCounts []uint32     // number of dns requests for each dns type - dnsV4Std, dnsV6Std
+	TipHeight int32        // consensus chain height of the network
+	Lagging   uint32       // number of confirmed good nodes behind the consensus height
 	mtx       sync.RWMutex // protect the structures
 }
 
 // configData holds information on the application
 type configData struct {
-	dnsUnknown uint64                // the number of dns requests for we are not configured to handle
-	uptime     time.Time             // application start time
-	port       string                // port for the dns server to listen on
-	http       string                // port for the web server to listen on
-	version    string                // application version
-	seeders    map[string]*dnsseeder // holds a pointer to all the current seeders
-	order      []string              // the order of loading the netfiles so we can display in this order
-	dns        map[string][]dns.RR   // holds details of all the currently served dns records
-	dnsmtx     sync.RWMutex          // protect the dns map
-	verbose    bool                  // verbose output cmdline option
-	debug      bool                  // debug cmdline option
-	stats      bool                  // stats cmdline option
+	dnsUnknown      uint64                // the number of dns requests for we are not configured to handle
+	uptime          time.Time             // application start time
+	port            string                // port for the dns server to listen on
+	http            string                // port for the web server to listen on
+	httpAddr        string                // host:port or unix:path for the web server to listen on
+	httpCert        string                // tls certificate file for the web server
+	httpKey         string                // tls key file for the web server
+	webAuth         *webAuth              // web users or nil if the web server is open
+	datadir         string                // directory to store theList snapshots
+	onionProxy      string                // default socks5 proxy used to crawl tor nodes
+	shutdownTimeout time.Duration         // time allowed to stop the servers and save snapshots
+	version         string                // application version
+	seeders         map[string]*dnsseeder // holds a pointer to all the current seeders
+	order           []string              // the order of loading the netfiles so we can display in this order
+	smtx            sync.RWMutex          // protect seeders & order. They are replaced on reload
+	dns             map[string]*dnsZone   // holds details of all the currently served dns records by zone
+	dnsmtx          sync.RWMutex          // protect the dns map
+	verbose         bool                  // verbose output cmdline option
+	debug           bool                  // debug cmdline option
+	stats           bool                  // stats cmdline option
 }
 
 var config configData
 var netfile string
+var webHtpasswd, webTokens, webAdmins string
 
 func main() {
 	config.version = "0.9.1"
@@ -49,6 +62,15 @@ func main() {
 	flag.StringVar(&netfile, "netfile", "", "List of json config files to load")
 	flag.StringVar(&config.port, "p", "8053", "DNS Port to listen on")
 	flag.StringVar(&config.http, "w", "", "Web Port to listen on. No port specified & no web server running")
+	flag.StringVar(&config.httpAddr, "w-addr", "", "Web address to listen on as host:port or unix:/path/to/socket. Overrides -w")
+	flag.StringVar(&config.httpCert, "w-cert", "", "TLS certificate file for the web server. Needs -w-key")
+	flag.StringVar(&config.httpKey, "w-key", "", "TLS key file for the web server")
+	flag.StringVar(&webHtpasswd, "w-htpasswd", "", "htpasswd file of basic auth web users. bcrypt or {SHA} hashes")
+	flag.StringVar(&webTokens, "w-tokens", "", "File of name:token bearer tokens for the web server")
+	flag.StringVar(&webAdmins, "w-admins", "", "Comma separated web users & token names with the admin role. Others are read only")
+	flag.StringVar(&config.datadir, "datadir", "", "Directory to save node snapshots. No directory specified & no snapshots saved")
+	flag.StringVar(&config.onionProxy, "onion-proxy", "", "Socks5 proxy host:port used to crawl tor onion nodes. e.g. 127.0.0.1:9050")
+	flag.DurationVar(&config.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed on shutdown to drain dns & web requests and save node snapshots")
 	flag.BoolVar(&config.verbose, "v", false, "Display verbose output")
 	flag.BoolVar(&config.debug, "d", false, "Display debug output")
 	flag.BoolVar(&config.stats, "s", false, "Display stats output")
@@ -61,22 +83,34 @@ func main() {
 		os.Exit(1)
 	}
 
-	config.seeders = make(map[string]*dnsseeder)
-	config.dns = make(map[string][]dns.RR)
-	config.order = []string{}
-
-	for _, nwFile := range netwFiles {
-		nnw, err := loadNetwork(nwFile)
-		if err != nil {
-			fmt.Printf("Error loading data from netfile %s - %v\n", nwFile, err)
+	if config.datadir != "" {
+		if err := os.MkdirAll(config.datadir, 0700); err != nil {
+			fmt.Printf("Error creating datadir %s - %v\n", config.datadir, err)
 			os.Exit(1)
 		}
-		if nnw != nil {
-			config.seeders[nnw.name] = nnw
-			config.order = append(config.order, nnw.name)
-		}
 	}
 
+	if (config.httpCert == "") != (config.httpKey == "") {
+		fmt.Printf("Error - web tls needs both -w-cert and -w-key\n")
+		os.Exit(1)
+	}
+	var err error
+	config.webAuth, err = loadWebAuth(webHtpasswd, webTokens, webAdmins)
+	if err != nil {
+		fmt.Printf("Error loading web users - %v\n", err)
+		os.Exit(1)
+	}
+
+	config.dns = make(map[string]*dnsZone)
+
+	seeders, order, err := loadNetworks(netwFiles)
+	if err != nil {
+		fmt.Printf("Error - %v\n", err)
+		os.Exit(1)
+	}
+	config.seeders = seeders
+	config.order = order
+
 	if config.debug {
 		config.verbose = true
 		config.stats = true
@@ -85,7 +119,7 @@ func main() {
 		config.stats = true
 	}
 
-	for _, v := range config.seeders {
+	for _, v := range activeSeeders() {
 		log.Printf("status - system is configured for network: %s\n", v.name)
 	}
 
@@ -94,46 +128,84 @@ func main() {
 	}
 
 	// start the web interface if we want it running
-	if config.http != "" {
-		go startHTTP(config.http)
+	var web *http.Server
+	if addr := webAddr(config.http, config.httpAddr); addr != "" {
+		web = startHTTP(addr)
 	}
 
 	// start dns server
 	dns.HandleFunc(".", handleDNS)
-	go serve("udp", config.port)
-	// RFC 7766 Sec. 5: "Authoritative server implementations MUST support TCP"
-	go serve("tcp", config.port)
+	dnsServers := []*dns.Server{
+		serve("udp", config.port),
+		// RFC 7766 Sec. 5: "Authoritative server implementations MUST support TCP"
+		serve("tcp", config.port),
+	}
 
 	var wg sync.WaitGroup
 
-	done := make(chan struct{})
+	// cancelling the context stops all seeders and their crawls
+	ctx, stopSeeders := context.WithCancel(context.Background())
+	defer stopSeeders()
+
 	// start a goroutine for each seeder
 	for _, s := range config.seeders {
 		wg.Add(1)
-		go s.runSeeder(done, &wg)
+		go s.runSeeder(ctx, &wg)
 	}
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-
-	// block until a signal is received
-	fmt.Println("\nShutting down on signal:", <-sig)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// FIXME - call dns server.Shutdown()
+	// block until a shutdown signal is received. SIGHUP reloads the network files
+	sg := <-sig
+	for ; sg == syscall.SIGHUP; sg = <-sig {
+		log.Printf("status - reloading network files on signal: %v\n", sg)
+		reloadNetworks(ctx, netwFiles, &wg)
+	}
+	fmt.Println("\nShutting down on signal:", sg)
 
-	// close the done channel to signal to all seeders to shutdown
-	// and wait for them to exit
-	close(done)
-	wg.Wait()
+	shutdown(dnsServers, web, stopSeeders, &wg)
 	fmt.Printf("\nProgram exiting. Bye\n")
 }
 
+// shutdown stops the dns & web servers accepting requests and lets in-flight
+// requests finish. The seeders are then stopped, cancelling their crawls, and
+// save their snapshots. It all has to finish within the shutdown timeout
+func shutdown(dnsServers []*dns.Server, web *http.Server, stopSeeders context.CancelFunc, wg *sync.WaitGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range dnsServers {
+		if err := srv.ShutdownContext(ctx); err != nil {
+			log.Printf("error shutting down %s dns server: %v\n", srv.Net, err)
+		}
+	}
+	if web != nil {
+		if err := web.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down web server: %v\n", err)
+		}
+	}
+
+	stopSeeders()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		log.Printf("shutdown timeout of %s reached before all seeders stopped\n", config.shutdownTimeout)
+	}
+}
+
 // updateNodeCounts runs in a goroutine and updates the global stats with the latest
 // counts from a startCrawlers run
 func updateNodeCounts(s *dnsseeder, tcount uint32, started, totals []uint32) {
+	s.metrics.crawlsStarted(started)
 	s.counts.mtx.Lock()
 
-	for st := range []int{statusRG, statusCG, statusWG, statusNG} {
+	for st := range []int{statusRG, statusCG, statusWG, statusNG, statusWC} {
 		if config.stats {
 			log.Printf("%s: started crawler: %s total: %v started: %v\n", s.name, status2str(uint32(st)), totals[st], started[st])
 		}
@@ -160,16 +232,23 @@ func status2str(status uint32) string {
 		return "statusWG"
 	case statusNG:
 		return "statusNG"
+	case statusWC:
+		return "statusWC"
 	default:
 		return "Unknown"
 	}
 }
 
-// updateDNSCounts runs in a goroutine and updates the global stats for the number of DNS requests
-func updateDNSCounts(name, qtype string) {
-	var ndType uint32
-	var counted bool
+// updateDNSCounts runs in a goroutine and updates the stats for the number of DNS
+// requests. s is the seeder serving the zone of the request or nil if we do not
+// serve the zone
+func updateDNSCounts(s *dnsseeder, qtype string) {
+	if s == nil {
+		atomic.AddUint64(&config.dnsUnknown, 1)
+		return
+	}
 
+	var ndType uint32
 	switch qtype {
 	case "A":
 		ndType = dnsV4Std
@@ -179,17 +258,7 @@ func updateDNSCounts(name, qtype string) {
 		ndType = dnsInvalid
 	}
 
-	// for DNS requests we do not have a reference to a seeder so we have to find it
-	for _, s := range config.seeders {
-		s.counts.mtx.Lock()
-
-		if name == s.dnsHost+"." {
-			s.counts.DNSCounts[ndType]++
-			counted = true
-		}
-		s.counts.mtx.Unlock()
-	}
-	if !counted {
-		atomic.AddUint64(&config.dnsUnknown, 1)
-	}
+	s.counts.mtx.Lock()
+	s.counts.DNSCounts[ndType]++
+	s.counts.mtx.Unlock()
 }
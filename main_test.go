@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestShutdown(t *testing.T) {
+	config.datadir = t.TempDir()
+	config.shutdownTimeout = 5 * time.Second
+	defer func() {
+		config.datadir = ""
+		config.shutdownTimeout = 0
+	}()
+
+	// a node that accepts connections and never replies so the crawl is still
+	// running when the seeder is stopped
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	a := JNetwork{Name: "A", Chain: "regtest", DNSName: "a.example.com", InitialIPs: []string{ln.Addr().String()}}
+	seeders, order, err := loadNetworks([]string{writeNetFile(t, t.TempDir(), a)})
+	if err != nil {
+		t.Fatalf("unable to load networks: %v", err)
+	}
+	setTestSeeders(seeders, order)
+	config.dnsmtx.Lock()
+	config.dns = make(map[string]*dnsZone)
+	config.dnsmtx.Unlock()
+	defer func() {
+		setTestSeeders(nil, nil)
+		config.dnsmtx.Lock()
+		config.dns = nil
+		config.dnsmtx.Unlock()
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen for dns: %v", err)
+	}
+	dnsStarted := make(chan struct{})
+	dnsServer := &dns.Server{PacketConn: pc, Net: "udp", NotifyStartedFunc: func() { close(dnsStarted) }}
+	go dnsServer.ActivateAndServe()
+	<-dnsStarted
+
+	wl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen for http: %v", err)
+	}
+	web := &http.Server{Handler: http.NotFoundHandler()}
+	go web.Serve(wl)
+
+	var wg sync.WaitGroup
+	ctx, stopSeeders := context.WithCancel(context.Background())
+	defer stopSeeders()
+	s := seeders["A"]
+	wg.Add(1)
+	go s.runSeeder(ctx, &wg)
+
+	crawling := func() bool {
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+		nd, ok := s.theList[ln.Addr().String()]
+		return ok && nd.crawlActive
+	}
+	for i := 0; !crawling(); i++ {
+		if i == 100 {
+			t.Fatalf("node crawl not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	shutdown([]*dns.Server{dnsServer}, web, stopSeeders, &wg)
+	if d := time.Since(start); d >= config.shutdownTimeout {
+		t.Errorf("shutdown took %s and reached the timeout", d)
+	}
+
+	select {
+	case <-s.stopped:
+	default:
+		t.Fatalf("seeder still running after shutdown")
+	}
+	if _, err := os.Stat(s.snapshotFile()); err != nil {
+		t.Fatalf("snapshot not saved on shutdown: %v", err)
+	}
+
+	r := &dnsseeder{name: s.name, id: s.id, netSettings: netSettings{maxSize: 10}}
+	r.theList = make(map[string]*node)
+	if n, err := r.loadNodes(); err != nil || n != 1 {
+		t.Errorf("snapshot restored %d nodes, error %v. Expected 1 node", n, err)
+	}
+}
+
+func TestUpdateDNSCounts(t *testing.T) {
+	s := &dnsseeder{name: "TestNet"}
+	s.counts.DNSCounts = make([]uint32, maxDNSTypes)
+	unknown := atomic.LoadUint64(&config.dnsUnknown)
+
+	// queries served by a seeder count against it whatever the name in its zone
+	updateDNSCounts(s, "A")
+	updateDNSCounts(s, "AAAA")
+	updateDNSCounts(s, "TXT")
+	if c := s.counts.DNSCounts; c[dnsV4Std] != 1 || c[dnsV6Std] != 1 || c[dnsInvalid] != 1 {
+		t.Errorf("seeder dns counts %v", c)
+	}
+	if n := atomic.LoadUint64(&config.dnsUnknown); n != unknown {
+		t.Errorf("%d unknown queries counted for a served zone", n-unknown)
+	}
+
+	updateDNSCounts(nil, "A")
+	if n := atomic.LoadUint64(&config.dnsUnknown); n != unknown+1 {
+		t.Errorf("%d unknown queries counted, expected 1", n-unknown)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// crawlBuckets are the upper bounds in seconds of the crawl duration histogram
+	crawlBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 120, maxTo}
+	// answerBuckets are the upper bounds in bytes of the dns answer size histogram
+	answerBuckets = []float64{64, 128, 256, 512, 1024, ednsUDPSize, 2048, 4096, 16384, 65535}
+)
+
+// histogram counts observations in cumulative buckets like a prometheus histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64 // count of observations in each bucket. Not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// observe adds a value to the histogram
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+// queryKey holds the labels of the dns query counter
+type queryKey struct {
+	qtype  string
+	prefix string
+	rcode  string
+}
+
+// seederMetrics holds the counters for one seeder that are only exported on the
+// /metrics page. Methods are safe to call on a nil pointer so seeders built
+// without metrics still work
+type seederMetrics struct {
+	mtx        sync.Mutex
+	started    []uint64          // number of crawls started for each node status
+	crawls     map[string]uint64 // crawl results by outcome. ok or the crawl error location
+	crawlTime  *histogram        // crawl durations in seconds
+	queries    map[queryKey]uint64
+	answerSize *histogram     // size of dns answers in bytes
+	records    map[string]int // number of records published by the last updateDNS
+}
+
+func newSeederMetrics() *seederMetrics {
+	return &seederMetrics{
+		started:    make([]uint64, maxStatusTypes),
+		crawls:     make(map[string]uint64),
+		crawlTime:  newHistogram(crawlBuckets),
+		queries:    make(map[queryKey]uint64),
+		answerSize: newHistogram(answerBuckets),
+		records:    make(map[string]int),
+	}
+}
+
+// crawlsStarted adds the crawls started by a startCrawlers run
+func (m *seederMetrics) crawlsStarted(started []uint32) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for st, n := range started {
+		if st < len(m.started) {
+			m.started[st] += uint64(n)
+		}
+	}
+}
+
+// crawlDone records the outcome and duration of a crawl
+func (m *seederMetrics) crawlDone(outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.crawls[outcome]++
+	m.crawlTime.observe(d.Seconds())
+}
+
+// query records a dns query and the size of the answer sent
+func (m *seederMetrics) query(qtype, prefix string, rcode, size int) {
+	if m == nil {
+		return
+	}
+	k := queryKey{qtype: qtype, prefix: prefix, rcode: rcodeString(rcode)}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.queries[k]++
+	m.answerSize.observe(float64(size))
+}
+
+// published records the number of records of each type published in dns
+func (m *seederMetrics) published(records map[string]int) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.records = records
+}
+
+// crawlOutcome returns the outcome label of a crawl result
+func crawlOutcome(r *result) string {
+	switch {
+	case r.msg != nil:
+		return r.msg.loc
+	case r.wrongChain != "":
+		return "wrong chain"
+	}
+	return "ok"
+}
+
+// queryPrefix returns the kinds of filter labels used in a query name with
+// their values removed so the number of label values stays small, e.g. the
+// query x9.n5.seed.example.com has the prefix n.x
+func queryPrefix(name, zone, onionLabel string) string {
+	if name == zone {
+		return ""
+	}
+	sub := strings.TrimSuffix(name, "."+zone)
+	var kinds []string
+	for _, label := range strings.Split(sub, ".") {
+		switch {
+		case label == onionLabel:
+			kinds = append(kinds, "onion")
+		case strings.HasPrefix(label, "0x"):
+			kinds = append(kinds, "x")
+		case label[0] == 'l':
+			kinds = append(kinds, "n")
+		default:
+			kinds = append(kinds, label[:1])
+		}
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ".")
+}
+
+// rcodeString returns the name of a dns response code
+func rcodeString(rcode int) string {
+	if s, ok := dnsRcodes[rcode]; ok {
+		return s
+	}
+	return strconv.Itoa(rcode)
+}
+
+var dnsRcodes = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	16: "BADVERS",
+}
+
+// metricsHandler outputs the seeder statistics in the prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, activeSeeders())
+
+	if config.verbose {
+		log.Printf("status - processed metrics request: %s\n", r.RemoteAddr)
+	}
+}
+
+// writeMetrics writes the metrics for the seeders in the prometheus text format
+func writeMetrics(w io.Writer, seeders []*dnsseeder) {
+	metricHeader(w, "dnsseeder_start_time_seconds", "gauge", "Time the dnsseeder started in seconds since the epoch")
+	fmt.Fprintf(w, "dnsseeder_start_time_seconds %d\n", config.uptime.Unix())
+	metricHeader(w, "dnsseeder_dns_unknown_queries_total", "counter", "DNS queries for zones we do not serve")
+	fmt.Fprintf(w, "dnsseeder_dns_unknown_queries_total %d\n", atomic.LoadUint64(&config.dnsUnknown))
+
+	metricHeader(w, "dnsseeder_nodes", "gauge", "Nodes in the node list by status")
+	for _, s := range seeders {
+		s.counts.mtx.RLock()
+		for st := uint32(0); st < maxStatusTypes && int(st) < len(s.counts.NdStatus); st++ {
+			fmt.Fprintf(w, "dnsseeder_nodes{%s} %d\n", labels("seeder", s.name, "status", status2str(st)), s.counts.NdStatus[st])
+		}
+		s.counts.mtx.RUnlock()
+	}
+
+	metricHeader(w, "dnsseeder_tip_height", "gauge", "Consensus chain height from the confirmed good nodes")
+	for _, s := range seeders {
+		s.counts.mtx.RLock()
+		fmt.Fprintf(w, "dnsseeder_tip_height{%s} %d\n", labels("seeder", s.name), s.counts.TipHeight)
+		s.counts.mtx.RUnlock()
+	}
+
+	metricHeader(w, "dnsseeder_lagging_nodes", "gauge", "Confirmed good nodes behind the consensus height")
+	for _, s := range seeders {
+		s.counts.mtx.RLock()
+		fmt.Fprintf(w, "dnsseeder_lagging_nodes{%s} %d\n", labels("seeder", s.name), s.counts.Lagging)
+		s.counts.mtx.RUnlock()
+	}
+
+	metricHeader(w, "dnsseeder_crawls_started_total", "counter", "Crawls started by node status")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			for st, n := range m.started {
+				fmt.Fprintf(w, "dnsseeder_crawls_started_total{%s} %d\n", labels("seeder", s.name, "status", status2str(uint32(st))), n)
+			}
+		})
+	}
+
+	metricHeader(w, "dnsseeder_crawls_total", "counter", "Finished crawls by outcome. The outcome is ok or where the crawl failed")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			for _, o := range sortedKeys(m.crawls) {
+				fmt.Fprintf(w, "dnsseeder_crawls_total{%s} %d\n", labels("seeder", s.name, "outcome", o), m.crawls[o])
+			}
+		})
+	}
+
+	metricHeader(w, "dnsseeder_crawl_duration_seconds", "histogram", "Time taken to crawl a node")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			writeHistogram(w, "dnsseeder_crawl_duration_seconds", labels("seeder", s.name), m.crawlTime)
+		})
+	}
+
+	metricHeader(w, "dnsseeder_dns_queries_total", "counter", "DNS queries by type, filter label prefix & response code")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			keys := make([]queryKey, 0, len(m.queries))
+			for k := range m.queries {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				a, b := keys[i], keys[j]
+				if a.qtype != b.qtype {
+					return a.qtype < b.qtype
+				}
+				if a.prefix != b.prefix {
+					return a.prefix < b.prefix
+				}
+				return a.rcode < b.rcode
+			})
+			for _, k := range keys {
+				fmt.Fprintf(w, "dnsseeder_dns_queries_total{%s} %d\n",
+					labels("seeder", s.name, "qtype", k.qtype, "prefix", k.prefix, "rcode", k.rcode), m.queries[k])
+			}
+		})
+	}
+
+	metricHeader(w, "dnsseeder_dns_answer_bytes", "histogram", "Size of the DNS answers sent")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			writeHistogram(w, "dnsseeder_dns_answer_bytes", labels("seeder", s.name), m.answerSize)
+		})
+	}
+
+	metricHeader(w, "dnsseeder_dns_records", "gauge", "Records published by the last DNS update by type")
+	for _, s := range seeders {
+		withMetrics(s, func(m *seederMetrics) {
+			for _, t := range sortedKeys(m.records) {
+				fmt.Fprintf(w, "dnsseeder_dns_records{%s} %d\n", labels("seeder", s.name, "qtype", t), m.records[t])
+			}
+		})
+	}
+}
+
+// withMetrics calls f with the seeder metrics locked if the seeder has metrics
+func withMetrics(s *dnsseeder, f func(m *seederMetrics)) {
+	s.mtx.RLock()
+	m := s.metrics
+	s.mtx.RUnlock()
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	f(m)
+}
+
+// metricHeader writes the HELP & TYPE lines of a metric
+func metricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeHistogram writes the bucket, sum & count lines of a histogram
+func writeHistogram(w io.Writer, name, lbls string, h *histogram) {
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, lbls, strconv.FormatFloat(b, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, lbls, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, lbls, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, lbls, h.count)
+}
+
+// labels returns the label pairs formatted for the text format with the values
+// escaped
+func labels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", kv[i], labelEscaper.Replace(kv[i+1]))
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
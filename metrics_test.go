@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryPrefix(t *testing.T) {
+	zone := "seed.example.com."
+	for name, want := range map[string]string{
+		"seed.example.com.":          "",
+		"x9.seed.example.com.":       "x",
+		"0x9.seed.example.com.":      "x",
+		"n5.x9.seed.example.com.":    "n.x",
+		"l5.r3.seed.example.com.":    "n.r",
+		"onion.x9.seed.example.com.": "onion.x",
+	} {
+		if got := queryPrefix(name, zone, "onion"); got != want {
+			t.Errorf("%s: prefix %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	s := setupTestDNS(t)
+	s.name = "Test\"Net"
+	s.metrics = newSeederMetrics()
+	s.updateDNS()
+
+	m := new(dns.Msg)
+	m.SetQuestion("x9.seed.example.com.", dns.TypeAAAA)
+	query(t, udpClient, m)
+	m.SetQuestion("bad.seed.example.com.", dns.TypeA)
+	query(t, udpClient, m)
+
+	var k string
+	for k = range s.theList {
+		break
+	}
+	s.processResult(&result{node: k, msg: &crawlError{"manual dial", errors.New("refused")}})
+	s.metrics.crawlsStarted([]uint32{3, 0, 0, 0, 0})
+
+	var b strings.Builder
+	writeMetrics(&b, []*dnsseeder{s})
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE dnsseeder_crawl_duration_seconds histogram\n",
+		`dnsseeder_crawls_started_total{seeder="Test\"Net",status="statusRG"} 3` + "\n",
+		`dnsseeder_crawls_total{seeder="Test\"Net",outcome="manual dial"} 1` + "\n",
+		`dnsseeder_crawl_duration_seconds_count{seeder="Test\"Net"} 1` + "\n",
+		`dnsseeder_crawl_duration_seconds_bucket{seeder="Test\"Net",le="+Inf"} 1` + "\n",
+		`dnsseeder_dns_queries_total{seeder="Test\"Net",qtype="AAAA",prefix="x",rcode="NOERROR"} 1` + "\n",
+		`dnsseeder_dns_queries_total{seeder="Test\"Net",qtype="A",prefix="invalid",rcode="NXDOMAIN"} 1` + "\n",
+		`dnsseeder_dns_answer_bytes_count{seeder="Test\"Net"} 2` + "\n",
+		`dnsseeder_dns_records{seeder="Test\"Net",qtype="AAAA"} 100` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("metrics output:\n%s", out)
+	}
+}
@@ -1,96 +1,560 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ltcsuite/ltcd/blockchain"
+	"github.com/ltcsuite/ltcd/chaincfg"
+	"github.com/ltcsuite/ltcd/chaincfg/chainhash"
 	"github.com/ltcsuite/ltcd/wire"
+	"github.com/miekg/dns"
 )
 
 // JNetwork is the exported struct that is read from the network file
 type JNetwork struct {
-	Name       string
-	Desc       string
-	ID         string
-	Port       uint16
-	Pver       uint32
-	DNSName    string
-	TTL        uint32
-	InitialIPs []string
-	Seeders    []string
+	Name        string
+	Desc        string
+	ID          string
+	Chain       string
+	Genesis     string
+	Port        uint16
+	Pver        uint32
+	DNSName     string
+	TTL         uint32
+	InitialIPs  []string
+	Seeders     []string
+	MaxAnswers  int
+	NameServers []string
+	Mbox        string
+	SOARefresh  uint32
+	SOARetry    uint32
+	SOAExpire   uint32
+	SOAMinTTL   uint32
+	OnionProxy  string
+	OnionLabel  string
+	BanFile     string
+	// optional policy a confirmed good node must meet before it is published in
+	// dns. Uptimes are percentages. Zero values disable each check
+	MinUptime2H  float64
+	MinUptime8H  float64
+	MinUptime1D  float64
+	MinUptime7D  float64
+	MinUptime30D float64
+	MinSuccess   uint32
+	MinVersion   int32
+	PublishPool  int
+	MaxBlockLag  int32
+	Checkpoints  []JCheckpoint
+	// optional crawl tuning. MaxStart & Delay hold one value for each node
+	// status in the order RG, CG, WG, NG, WC. Delays are in seconds apart from
+	// AuditDelay which is in minutes
+	MaxStart   []uint32
+	Delay      []int64
+	MaxSize    int
+	CrawlDelay int64
+	AuditDelay int64
+	DNSDelay   int64
+	MaxFails   uint32
 }
 
-func loadNetwork(fName string) (*dnsseeder, error) {
+// JCheckpoint is a known block on the network chain. Hash is in the usual
+// display byte order and Bits is the compact target of the block in hex
+type JCheckpoint struct {
+	Height int32
+	Hash   string
+	Bits   string
+}
+
+// readNetworkFile reads and decodes a network file
+func readNetworkFile(fName string) (JNetwork, error) {
+	var jnw JNetwork
+
 	nwFile, err := os.Open(fName)
 	if err != nil {
-		return nil, fmt.Errorf("error reading network file: %v", err)
+		return jnw, fmt.Errorf("error reading network file: %v", err)
 	}
 
 	defer nwFile.Close()
 
-	var jnw JNetwork
-
 	jsonParser := json.NewDecoder(nwFile)
 	if err = jsonParser.Decode(&jnw); err != nil {
-		return nil, fmt.Errorf("error decoding network file: %v", err)
+		return jnw, fmt.Errorf("error decoding network file: %v", err)
 	}
+	return jnw, nil
+}
 
+func loadNetwork(fName string) (*dnsseeder, error) {
+	jnw, err := readNetworkFile(fName)
+	if err != nil {
+		return nil, err
+	}
 	return initNetwork(jnw)
 }
 
-func initNetwork(jnw JNetwork) (*dnsseeder, error) {
+// loadNetworks loads the network files and checks the networks do not clash.
+// The seeders are returned by name along with the order of the files
+func loadNetworks(files []string) (map[string]*dnsseeder, []string, error) {
+	seeders := make(map[string]*dnsseeder)
+	var order []string
+	for _, f := range files {
+		s, err := loadNetwork(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading data from netfile %s - %v", f, err)
+		}
+		if dup, err := isDuplicateSeeder(s, seeders); dup {
+			return nil, nil, err
+		}
+		seeders[s.name] = s
+		order = append(order, s.name)
+	}
+	return seeders, order, nil
+}
 
-	if jnw.Port == 0 {
-		return nil, fmt.Errorf("invalid port supplied: %v", jnw.Port)
+// sameChain returns true if two network files are for the same chain & zone so a
+// running seeder can take the settings of the new file without a restart
+func sameChain(a, b JNetwork) bool {
+	return a.ID == b.ID && strings.EqualFold(a.Chain, b.Chain) && a.Genesis == b.Genesis &&
+		strings.EqualFold(strings.TrimSuffix(a.DNSName, "."), strings.TrimSuffix(b.DNSName, "."))
+}
+
+// netUpdate holds the new settings for a running seeder
+type netUpdate struct {
+	s        *dnsseeder
+	ns       netSettings
+	fileBans []*ban
+	changed  bool
+}
+
+// reloadNetworks reloads the network files. Networks for the same chain & zone
+// keep running with the new settings applied in place. Networks with a new ID,
+// Chain or DNSName are restarted and take over the node list of the old seeder
+// and removed networks are shut down. If any file fails to load then the running
+// networks are left untouched
+func reloadNetworks(ctx context.Context, files []string, wg *sync.WaitGroup) {
+	config.smtx.RLock()
+	running := config.seeders
+	config.smtx.RUnlock()
+
+	// check every file before changing anything. Only new & restarted networks
+	// need a new seeder
+	seeders := make(map[string]*dnsseeder)
+	var order []string
+	var updates []netUpdate
+	for _, f := range files {
+		s, u, err := reloadNetwork(f, running)
+		if err != nil {
+			log.Printf("reload failed so keeping the current networks: error loading data from netfile %s - %v\n", f, err)
+			return
+		}
+		if dup, err := isDuplicateSeeder(s, seeders); dup {
+			log.Printf("reload failed so keeping the current networks: %v\n", err)
+			return
+		}
+		if u != nil {
+			updates = append(updates, *u)
+		}
+		seeders[s.name] = s
+		order = append(order, s.name)
+	}
+
+	for _, u := range updates {
+		if u.changed {
+			log.Printf("status - reload updating network: %s\n", u.s.name)
+			u.s.applySettings(u.ns)
+			u.s.addInitialIPs()
+		}
+		if u.ns.conf.BanFile != "" || u.s.fileBans != nil {
+			u.s.setFileBans(u.fileBans)
+		}
+	}
+
+	var start []*dnsseeder
+	for name, s := range seeders {
+		old, ok := running[name]
+		if old == s {
+			continue
+		}
+		if !ok {
+			log.Printf("status - reload adding network: %s\n", name)
+			start = append(start, s)
+			continue
+		}
+		log.Printf("status - reload restarting network: %s\n", name)
+		old.shutdown()
+		<-old.stopped
+		if old.id == s.id {
+			s.takeOver(old)
+			s.addInitialIPs()
+		}
+		if old.dnsHost != s.dnsHost {
+			removeZone(old.dnsHost)
+		}
+		start = append(start, s)
+	}
+	for name, old := range running {
+		if _, ok := seeders[name]; !ok {
+			log.Printf("status - reload removing network: %s\n", name)
+			old.shutdown()
+			<-old.stopped
+			removeZone(old.dnsHost)
+		}
+	}
+
+	config.smtx.Lock()
+	config.seeders = seeders
+	config.order = order
+	config.smtx.Unlock()
+
+	for _, s := range start {
+		wg.Add(1)
+		go s.runSeeder(ctx, wg)
+	}
+}
+
+// reloadNetwork reads a network file during a reload. A running seeder for the
+// same chain & zone is returned with the settings to apply to it. Otherwise a new
+// seeder is returned
+func reloadNetwork(fName string, running map[string]*dnsseeder) (*dnsseeder, *netUpdate, error) {
+	jnw, err := readNetworkFile(fName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only the reload goroutine changes the settings so they can be read unlocked
+	old, ok := running[jnw.Name]
+	if !ok || !sameChain(old.conf, jnw) {
+		s, err := initNetwork(jnw)
+		return s, nil, err
+	}
 
+	u := &netUpdate{s: old, ns: old.netSettings}
+	if !reflect.DeepEqual(old.conf, jnw) {
+		if u.ns, err = newSettings(jnw, old.chainParams); err != nil {
+			return nil, nil, err
+		}
+		u.changed = true
 	}
+	// the ban file is read again even if the network file has not changed
+	if u.fileBans, err = loadFileBans(jnw); err != nil {
+		return nil, nil, err
+	}
+	return old, u, nil
+}
+
+// removeZone stops serving the dns records for a zone
+func removeZone(dnsHost string) {
+	config.dnsmtx.Lock()
+	delete(config.dns, dns.Fqdn(dnsHost))
+	config.dnsmtx.Unlock()
+}
+
+func initNetwork(jnw JNetwork) (*dnsseeder, error) {
 
 	if jnw.DNSName == "" {
 		return nil, fmt.Errorf("no dns hostname supplied")
 	}
 
-	// init the seeder
-	seeder := &dnsseeder{}
-	seeder.theList = make(map[string]*node)
-	seeder.port = jnw.Port
-	seeder.pver = jnw.Pver
-	seeder.ttl = jnw.TTL
-	seeder.name = jnw.Name
-	seeder.desc = jnw.Desc
-	seeder.dnsHost = jnw.DNSName
+	// conver the network magic number to a Uint32. It can be left out if a
+	// known chain is named
+	var id wire.BitcoinNet
+	if jnw.ID != "" {
+		t1, err := strconv.ParseUint(jnw.ID, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Error converting Network Magic number: %v", err)
+		}
+		id = wire.BitcoinNet(t1)
+	}
 
-	// conver the network magic number to a Uint32
-	t1, err := strconv.ParseUint(jnw.ID, 0, 32)
+	params, err := chainParams(jnw, id)
 	if err != nil {
-		return nil, fmt.Errorf("Error converting Network Magic number: %v", err)
+		return nil, err
 	}
-	seeder.id = wire.BitcoinNet(t1)
-
-	seeder.initialIPs = jnw.InitialIPs
 
-	// load the seeder dns
-	seeder.seeders = jnw.Seeders
+	ns, err := newSettings(jnw, params)
+	if err != nil {
+		return nil, err
+	}
 
-	// add some checks to the start & delay values to keep them sane
-	seeder.maxStart = []uint32{20, 20, 20, 30}
-	seeder.delay = []int64{210, 789, 234, 1876}
-	seeder.maxSize = 1250
+	// init the seeder
+	seeder := &dnsseeder{netSettings: ns}
+	seeder.theList = make(map[string]*node)
+	seeder.chainParams = params
+	seeder.id = params.Net
+	seeder.name = jnw.Name
+	seeder.dnsHost = dnsHostName(jnw.DNSName)
 
 	// initialize the stats counters
 	seeder.counts.NdStatus = make([]uint32, maxStatusTypes)
 	seeder.counts.NdStarts = make([]uint32, maxStatusTypes)
 	seeder.counts.DNSCounts = make([]uint32, maxDNSTypes)
+	seeder.metrics = newSeederMetrics()
 
-	// some sanity checks on the loaded config options
-	if seeder.ttl < 60 {
-		seeder.ttl = 60
+	// bans added by admins are kept in the data directory. The operator can also
+	// list bans in a ban file that is read again on reload
+	if err := seeder.loadBans(); err != nil {
+		return nil, err
 	}
-
-	if dup, err := isDuplicateSeeder(seeder); dup {
+	if seeder.fileBans, err = loadFileBans(jnw); err != nil {
 		return nil, err
 	}
 
+	seeder.crawlNow = make(chan string)
+	seeder.retune = make(chan struct{}, 1)
+	seeder.quit = make(chan struct{})
+	seeder.stopped = make(chan struct{})
+
 	return seeder, nil
 }
+
+// dnsHostName returns the zone name of the seeder from the network file DNSName
+func dnsHostName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// loadFileBans reads the ban file of the network if it has one
+func loadFileBans(jnw JNetwork) ([]*ban, error) {
+	if jnw.BanFile == "" {
+		return nil, nil
+	}
+	return readBanFile(jnw.BanFile)
+}
+
+// newSettings checks the settings in a network file for the chain and returns them
+func newSettings(jnw JNetwork, params *chaincfg.Params) (netSettings, error) {
+	ns := netSettings{conf: jnw}
+
+	// the port defaults to the chain port
+	if jnw.Port == 0 {
+		p, err := strconv.ParseUint(params.DefaultPort, 10, 16)
+		if err != nil || p == 0 {
+			return ns, fmt.Errorf("invalid port supplied: %v", jnw.Port)
+		}
+		jnw.Port = uint16(p)
+	}
+
+	ns.port = jnw.Port
+	ns.pver = jnw.Pver
+	ns.ttl = jnw.TTL
+	ns.desc = jnw.Desc
+	ns.initialIPs = jnw.InitialIPs
+
+	// load the seeder dns
+	ns.seeders = jnw.Seeders
+
+	// crawl tuning. Each value is optional and checked to keep it sane
+	if err := ns.setTuning(jnw); err != nil {
+		return ns, err
+	}
+
+	// some sanity checks on the loaded config options
+	if ns.ttl < 60 {
+		ns.ttl = 60
+	}
+
+	// limit the number of records returned in one dns answer
+	ns.maxAnswers = jnw.MaxAnswers
+	if ns.maxAnswers <= 0 {
+		ns.maxAnswers = defaultAnswers
+	}
+	if ns.maxAnswers > maxAnswers {
+		ns.maxAnswers = maxAnswers
+	}
+
+	// good node policy. The most reliable nodes passing the policy are published
+	ns.policy.minUptime = [maxStatWindows]float64{
+		stat2H: jnw.MinUptime2H,
+		stat8H: jnw.MinUptime8H,
+		stat1D: jnw.MinUptime1D,
+		stat1W: jnw.MinUptime7D,
+		stat1M: jnw.MinUptime30D,
+	}
+	for _, min := range ns.policy.minUptime {
+		if min < 0 || min > 100 {
+			return ns, fmt.Errorf("invalid minimum uptime %v. Must be a percentage", min)
+		}
+	}
+	ns.policy.minSuccess = jnw.MinSuccess
+	ns.policy.minVersion = jnw.MinVersion
+	if jnw.PublishPool < 0 {
+		return ns, fmt.Errorf("invalid publish pool size: %v", jnw.PublishPool)
+	}
+	ns.pool = jnw.PublishPool
+	if ns.pool == 0 {
+		ns.pool = defaultPool * ns.maxAnswers
+	}
+
+	// nodes too far behind the consensus chain height are not published
+	if jnw.MaxBlockLag < 0 {
+		return ns, fmt.Errorf("invalid max block lag: %v", jnw.MaxBlockLag)
+	}
+	ns.maxBlockLag = jnw.MaxBlockLag
+
+	// nodes are asked for the headers after a checkpoint to prove they are on our chain
+	for _, jcp := range jnw.Checkpoints {
+		h, err := chainhash.NewHashFromStr(jcp.Hash)
+		if err != nil || len(jcp.Hash) != chainhash.MaxHashStringSize {
+			return ns, fmt.Errorf("invalid checkpoint hash %s", jcp.Hash)
+		}
+		if jcp.Height <= 0 {
+			return ns, fmt.Errorf("invalid checkpoint height %v", jcp.Height)
+		}
+		cp := checkpoint{height: jcp.Height, hash: *h}
+		if jcp.Bits == "" {
+			log.Printf("%s: warning - checkpoint %d has no Bits. Nodes on a low difficulty chain built on the checkpoint will pass\n",
+				jnw.Name, jcp.Height)
+		} else {
+			bits, err := strconv.ParseUint(strings.TrimPrefix(jcp.Bits, "0x"), 16, 32)
+			target := blockchain.CompactToBig(uint32(bits))
+			if err != nil || target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+				return ns, fmt.Errorf("invalid checkpoint bits %s", jcp.Bits)
+			}
+			cp.bits = uint32(bits)
+		}
+		ns.checkpoints = append(ns.checkpoints, cp)
+	}
+
+	// tor nodes are crawled through a socks5 proxy. The network file overrides the
+	// -onion-proxy command line option
+	ns.onionProxy = config.onionProxy
+	if jnw.OnionProxy != "" {
+		ns.onionProxy = jnw.OnionProxy
+	}
+	if ns.onionProxy != "" {
+		if _, _, err := net.SplitHostPort(ns.onionProxy); err != nil {
+			return ns, fmt.Errorf("invalid onion proxy %s: %v", ns.onionProxy, err)
+		}
+	}
+
+	// tor nodes are published as TXT records below this label of the zone
+	ns.onionLabel = strings.ToLower(jnw.OnionLabel)
+	if ns.onionLabel == "" {
+		ns.onionLabel = "onion"
+	}
+	if _, ok := dns.IsDomainName(ns.onionLabel); !ok || strings.Contains(ns.onionLabel, ".") {
+		return ns, fmt.Errorf("invalid onion label: %s", ns.onionLabel)
+	}
+	if _, ok := parseQueryName(ns.onionLabel+".x", "x", ""); ok {
+		return ns, fmt.Errorf("onion label %s clashes with a query filter label", ns.onionLabel)
+	}
+
+	// authoritative zone details used for the SOA & NS records
+	for _, n := range jnw.NameServers {
+		if n = strings.TrimSpace(n); n != "" {
+			ns.nameServers = append(ns.nameServers, dns.Fqdn(n))
+		}
+	}
+	ns.mbox = mboxName(jnw.Mbox, dnsHostName(jnw.DNSName))
+	ns.soaRefresh = defaultUint32(jnw.SOARefresh, 604800)
+	ns.soaRetry = defaultUint32(jnw.SOARetry, 86400)
+	ns.soaExpire = defaultUint32(jnw.SOAExpire, 2592000)
+	ns.soaMinTTL = defaultUint32(jnw.SOAMinTTL, ns.ttl)
+
+	return ns, nil
+}
+
+// default crawl tuning for each node status
+var (
+	defaultMaxStart = [maxStatusTypes]uint32{20, 20, 20, 30, 5}
+	defaultDelay    = [maxStatusTypes]int64{210, 789, 234, 1876, 21600}
+)
+
+// setTuning sets the crawl tuning of the seeder from the network file. Values
+// that are not set take the defaults and values out of bounds are an error
+func (s *netSettings) setTuning(jnw JNetwork) error {
+	if len(jnw.MaxStart) > maxStatusTypes || len(jnw.Delay) > maxStatusTypes {
+		return fmt.Errorf("MaxStart & Delay can have at most %d values", maxStatusTypes)
+	}
+
+	maxStart := defaultMaxStart
+	for i, v := range jnw.MaxStart {
+		if v > maxCrawlStart {
+			return fmt.Errorf("invalid MaxStart %v for %s. Must be at most %v", v, status2str(uint32(i)), maxCrawlStart)
+		}
+		maxStart[i] = v
+	}
+	delay := defaultDelay
+	for i, v := range jnw.Delay {
+		if v < minNodeDelay || v > maxNodeDelay {
+			return fmt.Errorf("invalid Delay %v for %s. Must be %v to %v seconds", v, status2str(uint32(i)), minNodeDelay, maxNodeDelay)
+		}
+		delay[i] = v
+	}
+
+	maxSize := jnw.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+	if maxSize < minMaxSize || maxSize > maxMaxSize {
+		return fmt.Errorf("invalid MaxSize %v. Must be %v to %v", maxSize, minMaxSize, maxMaxSize)
+	}
+
+	crawlDelay, err := tickDelay("CrawlDelay", jnw.CrawlDelay, defaultCrawlDelay, maxTickDelay)
+	if err != nil {
+		return err
+	}
+	auditDelay, err := tickDelay("AuditDelay", jnw.AuditDelay, defaultAuditDelay, maxAuditDelay)
+	if err != nil {
+		return err
+	}
+	dnsDelay, err := tickDelay("DNSDelay", jnw.DNSDelay, defaultDNSDelay, maxTickDelay)
+	if err != nil {
+		return err
+	}
+
+	maxFails := defaultUint32(jnw.MaxFails, defaultMaxFails)
+	if maxFails > maxMaxFails {
+		return fmt.Errorf("invalid MaxFails %v. Must be at most %v", maxFails, maxMaxFails)
+	}
+
+	s.maxStart = maxStart[:]
+	s.delay = delay[:]
+	s.maxSize = maxSize
+	s.crawlDelay = time.Duration(crawlDelay) * time.Second
+	s.auditDelay = time.Duration(auditDelay) * time.Minute
+	s.dnsDelay = time.Duration(dnsDelay) * time.Second
+	s.maxFails = maxFails
+	return nil
+}
+
+// tickDelay returns v or def if v has not been set. v must be 1 to max
+func tickDelay(name string, v, def, max int64) (int64, error) {
+	if v == 0 {
+		return def, nil
+	}
+	if v < 1 || v > max {
+		return 0, fmt.Errorf("invalid %s %v. Must be 1 to %v", name, v, max)
+	}
+	return v, nil
+}
+
+// mboxName converts an email address into the domain name format used in the SOA
+// record. If no address is supplied then hostmaster in the seeder zone is used
+func mboxName(mbox, dnsHost string) string {
+	mbox = strings.TrimSpace(mbox)
+	if mbox == "" {
+		return dns.Fqdn("hostmaster." + dnsHost)
+	}
+	if i := strings.Index(mbox, "@"); i >= 0 {
+		// dots in the local part must be escaped. RFC 1035 Sec. 8
+		mbox = strings.ReplaceAll(mbox[:i], ".", "\\.") + "." + mbox[i+1:]
+	}
+	return dns.Fqdn(mbox)
+}
+
+// defaultUint32 returns v or def if v has not been set
+func defaultUint32(v, def uint32) uint32 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
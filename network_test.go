@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetTuning(t *testing.T) {
+	s := &dnsseeder{}
+	if err := s.setTuning(JNetwork{}); err != nil {
+		t.Fatalf("default tuning failed: %v", err)
+	}
+	if s.maxSize != defaultMaxSize || s.crawlDelay != defaultCrawlDelay*time.Second ||
+		s.auditDelay != defaultAuditDelay*time.Minute || s.maxFails != defaultMaxFails ||
+		len(s.maxStart) != maxStatusTypes || s.delay[statusWC] != defaultDelay[statusWC] {
+		t.Errorf("unexpected default tuning: %+v", s)
+	}
+
+	// values not in the file keep their defaults
+	jnw := JNetwork{MaxStart: []uint32{5, 6}, Delay: []int64{60}, MaxSize: 100, CrawlDelay: 5, AuditDelay: 2, DNSDelay: 10, MaxFails: 3}
+	if err := s.setTuning(jnw); err != nil {
+		t.Fatalf("tuning failed: %v", err)
+	}
+	if s.maxStart[statusRG] != 5 || s.maxStart[statusCG] != 6 || s.maxStart[statusNG] != defaultMaxStart[statusNG] ||
+		s.delay[statusRG] != 60 || s.delay[statusCG] != defaultDelay[statusCG] || s.maxSize != 100 ||
+		s.crawlDelay != 5*time.Second || s.auditDelay != 2*time.Minute || s.dnsDelay != 10*time.Second || s.maxFails != 3 {
+		t.Errorf("unexpected tuning: %+v", s)
+	}
+
+	for _, bad := range []JNetwork{
+		{MaxStart: make([]uint32, maxStatusTypes+1)},
+		{MaxStart: []uint32{maxCrawlStart + 1}},
+		{Delay: []int64{1}},
+		{MaxSize: 1},
+		{MaxSize: -5},
+		{CrawlDelay: -1},
+		{AuditDelay: maxAuditDelay + 1},
+		{DNSDelay: maxTickDelay + 1},
+		{MaxFails: maxMaxFails + 1},
+	} {
+		if err := s.setTuning(bad); err == nil {
+			t.Errorf("invalid tuning accepted: %+v", bad)
+		}
+	}
+	// a failed update leaves the old tuning in place
+	if s.maxSize != 100 {
+		t.Errorf("tuning changed by an invalid update")
+	}
+}
+
+// writeNetFile writes a network file to dir and returns its name
+func writeNetFile(t *testing.T, dir string, jnw JNetwork) string {
+	b, err := json.Marshal(jnw)
+	if err != nil {
+		t.Fatalf("unable to encode network file: %v", err)
+	}
+	fName := filepath.Join(dir, jnw.Name+".json")
+	if err := os.WriteFile(fName, b, 0600); err != nil {
+		t.Fatalf("unable to write network file: %v", err)
+	}
+	return fName
+}
+
+func TestReloadNetworks(t *testing.T) {
+	dir := t.TempDir()
+	a := JNetwork{Name: "A", Chain: "regtest", DNSName: "a.example.com", TTL: 60, InitialIPs: []string{"127.0.0.1:1"}}
+	b := JNetwork{Name: "B", Chain: "simnet", DNSName: "b.example.com"}
+	c := JNetwork{Name: "C", Chain: "signet", DNSName: "c.example.com"}
+	aFile := writeNetFile(t, dir, a)
+	bFile := writeNetFile(t, dir, b)
+
+	seeders, order, err := loadNetworks([]string{aFile, bFile})
+	if err != nil {
+		t.Fatalf("unable to load networks: %v", err)
+	}
+	setTestSeeders(seeders, order)
+	config.dnsmtx.Lock()
+	config.dns = make(map[string]*dnsZone)
+	config.dnsmtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range seeders {
+		wg.Add(1)
+		go s.runSeeder(context.Background(), &wg)
+	}
+	defer func() {
+		for _, s := range activeSeeders() {
+			s.shutdown()
+		}
+		wg.Wait()
+		setTestSeeders(nil, nil)
+		config.dnsmtx.Lock()
+		config.dns = nil
+		config.dnsmtx.Unlock()
+	}()
+
+	oldA, oldB := seeders["A"], seeders["B"]
+
+	// a bad file keeps the running networks
+	bad := writeNetFile(t, dir, JNetwork{Name: "Bad", Chain: "nonet", DNSName: "bad.example.com"})
+	reloadNetworks(context.Background(), []string{aFile, bad}, &wg)
+	if getSeederByName("A") != oldA || getSeederByName("B") != oldB {
+		t.Fatalf("networks changed by a failed reload")
+	}
+
+	// change A settings, drop B and add C
+	a.TTL = 120
+	a.InitialIPs = append(a.InitialIPs, "127.0.0.2:1")
+	writeNetFile(t, dir, a)
+	cFile := writeNetFile(t, dir, c)
+	reloadNetworks(context.Background(), []string{aFile, cFile}, &wg)
+
+	if getSeederByName("A") != oldA || oldA.settings().ttl != 120 {
+		t.Fatalf("network A not updated in place")
+	}
+	oldA.mtx.RLock()
+	_, found1 := oldA.theList["127.0.0.1:1"]
+	_, found2 := oldA.theList["127.0.0.2:1"]
+	oldA.mtx.RUnlock()
+	if !found1 || !found2 {
+		t.Errorf("network A did not keep its nodes and add the new initial IP")
+	}
+
+	select {
+	case <-oldB.stopped:
+	default:
+		t.Errorf("removed network B still running")
+	}
+	if getSeederByName("B") != nil || getSeederByZone("b.example.com.") != nil {
+		t.Errorf("removed network B still configured")
+	}
+	config.dnsmtx.RLock()
+	_, bZone := config.dns["b.example.com."]
+	config.dnsmtx.RUnlock()
+	if bZone {
+		t.Errorf("removed network B still has dns records")
+	}
+	if getSeederByName("C") == nil {
+		t.Errorf("network C not added")
+	}
+
+	// an unchanged file leaves the network running
+	reloadNetworks(context.Background(), []string{aFile, cFile}, &wg)
+	if getSeederByName("A") != oldA {
+		t.Errorf("unchanged network A restarted")
+	}
+
+	// a new dns name restarts the network with its nodes
+	a.DNSName = "a2.example.com"
+	writeNetFile(t, dir, a)
+	reloadNetworks(context.Background(), []string{aFile, cFile}, &wg)
+
+	newA := getSeederByName("A")
+	if newA == nil || newA == oldA || newA.dnsHost != "a2.example.com" {
+		t.Fatalf("network A not restarted")
+	}
+	select {
+	case <-oldA.stopped:
+	default:
+		t.Errorf("replaced network A still running")
+	}
+	newA.mtx.RLock()
+	_, found1 = newA.theList["127.0.0.1:1"]
+	newA.mtx.RUnlock()
+	if !found1 {
+		t.Errorf("restarted network A did not keep its nodes")
+	}
+	if getSeederByZone("a.example.com.") != nil {
+		t.Errorf("old zone of network A still served")
+	}
+}
+
+func TestReloadKeepsCrawls(t *testing.T) {
+	// a node that accepts connections and never replies so the crawl stays running
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	dir := t.TempDir()
+	a := JNetwork{Name: "A", Chain: "regtest", DNSName: "a.example.com", TTL: 60, InitialIPs: []string{ln.Addr().String()}}
+	aFile := writeNetFile(t, dir, a)
+
+	seeders, order, err := loadNetworks([]string{aFile})
+	if err != nil {
+		t.Fatalf("unable to load networks: %v", err)
+	}
+	setTestSeeders(seeders, order)
+	config.dnsmtx.Lock()
+	config.dns = make(map[string]*dnsZone)
+	config.dnsmtx.Unlock()
+
+	s := seeders["A"]
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.runSeeder(context.Background(), &wg)
+	defer func() {
+		s.shutdown()
+		wg.Wait()
+		setTestSeeders(nil, nil)
+		config.dnsmtx.Lock()
+		config.dns = nil
+		config.dnsmtx.Unlock()
+	}()
+
+	crawling := func() bool {
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+		nd, ok := s.theList[ln.Addr().String()]
+		return ok && nd.crawlActive
+	}
+	for i := 0; !crawling(); i++ {
+		if i == 100 {
+			t.Fatalf("node crawl not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	a.TTL = 300
+	writeNetFile(t, dir, a)
+	reloadNetworks(context.Background(), []string{aFile}, &wg)
+
+	if getSeederByName("A") != s {
+		t.Fatalf("ttl change restarted the network")
+	}
+	select {
+	case <-s.stopped:
+		t.Fatalf("ttl change stopped the network")
+	default:
+	}
+	if !crawling() {
+		t.Errorf("ttl change ended the crawl in progress")
+	}
+	rrs := zoneRecords("a.example.com.", dns.TypeSOA)
+	if len(rrs) != 1 || rrs[0].Header().Ttl != 300 {
+		t.Errorf("SOA not updated with the new ttl: %v", rrs)
+	}
+}
+
+func TestShippedConfigs(t *testing.T) {
+	files, err := filepath.Glob("configs/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no shipped configs found: %v", err)
+	}
+	for _, f := range files {
+		s, err := loadNetwork(f)
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if len(s.checkpoints) == 0 {
+			t.Errorf("%s: no checkpoints", f)
+		}
+		// the checkpoints must be on the chain ltcd knows
+		for _, cp := range s.checkpoints {
+			found := false
+			for _, c := range s.chainParams.Checkpoints {
+				if c.Height == cp.height && *c.Hash == cp.hash {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: checkpoint %d %s is not a %s checkpoint", f, cp.height, cp.hash, s.chainParams.Name)
+			}
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"encoding/base32"
+	"math"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ltcsuite/ltcd/wire"
@@ -8,20 +13,24 @@ import (
 
 // Node struct contains details on one client
 type node struct {
-	na           *wire.NetAddress // holds ip address & port details
-	lastConnect  time.Time        // last time we sucessfully connected to this client
-	lastTry      time.Time        // last time we tried to connect to this client
-	crawlStart   time.Time        // time when we started the last crawl
-	statusStr    string           // string with last error or OK details
-	strVersion   string           // remote client user agent
-	services     wire.ServiceFlag // remote client supported services
-	connectFails uint32           // number of times we have failed to connect to this client
-	version      int32            // remote client protocol version
-	lastBlock    int32            // remote client last block
-	status       uint32           // rg,cg,wg,ng
-	rating       uint32           // if it reaches 100 then we mark them statusNG
-	dnsType      uint32           // what dns type this client is
-	crawlActive  bool             // are we currently crawling this client
+	na           *wire.NetAddressV2 // holds address, port & services details
+	ip           net.IP             // ip address for ipv4 & ipv6 nodes. nil for non-ip networks
+	lastConnect  time.Time          // last time we sucessfully connected to this client
+	lastTry      time.Time          // last time we tried to connect to this client
+	crawlStart   time.Time          // time when we started the last crawl
+	statusStr    string             // string with last error or OK details
+	strVersion   string             // remote client user agent
+	services     wire.ServiceFlag   // remote client supported services
+	connectFails uint32             // number of times we have failed to connect to this client
+	version      int32              // remote client protocol version
+	lastBlock    int32              // remote client last block
+	status       uint32             // rg,cg,wg,ng
+	rating       uint32             // if it reaches 100 then we mark them statusNG
+	dnsType      uint32             // what dns type this client is
+	crawlActive  bool               // are we currently crawling this client
+	total        uint32             // number of crawls of this client
+	success      uint32             // number of successful crawls of this client
+	stats        [maxStatWindows]addrStat
 }
 
 // dns2str will return the string description of the dns type
@@ -31,7 +40,191 @@ func (nd node) dns2str() string {
 		return "v4 standard port"
 	case dnsV6Std:
 		return "v6 standard port"
+	case dnsTorV3:
+		return "tor v3 onion"
 	default:
 		return "Unknown DNS Type"
 	}
 }
+
+// statWindows are the time constants of the uptime statistics kept for each node
+var statWindows = [maxStatWindows]time.Duration{
+	2 * time.Hour,
+	8 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// indexes into statWindows & node.stats
+const (
+	stat2H = iota
+	stat8H
+	stat1D
+	stat1W
+	stat1M
+	maxStatWindows
+)
+
+// firstTryAge is the age used for the first crawl of a node as there is no previous try
+const firstTryAge = 1000 * time.Second
+
+// addrStat is an exponentially decaying record of crawl results over one time
+// window. It follows CAddrStat from the C++ bitcoin-seeder
+type addrStat struct {
+	weight      float64 // how much of the window has been observed
+	count       float64 // decaying number of crawls
+	reliability float64 // decaying fraction of successful crawls
+}
+
+// update decays the stat by the time since the last crawl and adds the new result
+func (st *addrStat) update(good bool, age, tau time.Duration) {
+	f := math.Exp(-age.Seconds() / tau.Seconds())
+	st.reliability *= f
+	if good {
+		st.reliability += 1.0 - f
+	}
+	st.count = st.count*f + 1
+	st.weight = st.weight*f + 1.0 - f
+}
+
+// updateStats records the result of a crawl in the node uptime statistics. It
+// must be called before lastTry is updated for this crawl
+func (nd *node) updateStats(good bool, now time.Time) {
+	age := firstTryAge
+	if !nd.lastTry.IsZero() {
+		age = now.Sub(nd.lastTry)
+	}
+	if age < 0 {
+		age = 0
+	}
+	nd.total++
+	if good {
+		nd.success++
+	}
+	for i := range nd.stats {
+		nd.stats[i].update(good, age, statWindows[i])
+	}
+}
+
+// uptime returns the reliability of the node over a window as a percentage
+func (nd *node) uptime(window int) float64 {
+	return 100.0 * nd.stats[window].reliability
+}
+
+// reliable reports if the uptime statistics of the node are good enough to hand
+// it out to clients. The thresholds are those used by the C++ bitcoin-seeder
+func (nd *node) reliable() bool {
+	if nd.total <= 3 && nd.success*2 >= nd.total {
+		return true
+	}
+	st := nd.stats
+	switch {
+	case st[stat2H].reliability > 0.85 && st[stat2H].count > 2:
+		return true
+	case st[stat8H].reliability > 0.70 && st[stat8H].count > 4:
+		return true
+	case st[stat1D].reliability > 0.55 && st[stat1D].count > 8:
+		return true
+	case st[stat1W].reliability > 0.45 && st[stat1W].count > 16:
+		return true
+	case st[stat1M].reliability > 0.35 && st[stat1M].count > 32:
+		return true
+	}
+	return false
+}
+
+// goodPolicy holds the per network requirements a confirmed good node must meet
+// before it is published in dns
+type goodPolicy struct {
+	minUptime  [maxStatWindows]float64 // minimum uptime percentage for each window. 0 to disable
+	minSuccess uint32                  // minimum number of successful crawls
+	minVersion int32                   // minimum remote protocol version. 0 to disable
+}
+
+// good reports if a node passes the policy. The node must also be reliable
+func (p *goodPolicy) good(nd *node) bool {
+	if !nd.reliable() || nd.success < p.minSuccess {
+		return false
+	}
+	if p.minVersion > 0 && nd.version < p.minVersion {
+		return false
+	}
+	for i, min := range p.minUptime {
+		if min > 0 && nd.uptime(i) < min {
+			return false
+		}
+	}
+	return true
+}
+
+// score ranks nodes by reliability. Nodes that have been up over all windows
+// score higher than nodes that have only recently come up
+func (nd *node) score() float64 {
+	var sc float64
+	for _, st := range nd.stats {
+		sc += st.reliability
+	}
+	return sc / maxStatWindows
+}
+
+// nodeKey returns the theList key for a network address
+func nodeKey(na *wire.NetAddressV2) string {
+	return net.JoinHostPort(na.Addr.String(), strconv.Itoa(int(na.Port)))
+}
+
+// addrType returns the dns type of a network address and the ip address for ipv4
+// and ipv6 addresses. dnsInvalid is returned for networks we can not track. I2P
+// and CJDNS addresses never get here as the ltcd wire package can not decode them
+// and skips them in addrv2 messages
+func addrType(na *wire.NetAddressV2) (uint32, net.IP) {
+	if na.IsTorV3() {
+		return dnsTorV3, nil
+	}
+	legacy := na.ToLegacy()
+	if legacy == nil || len(legacy.IP) == 0 {
+		return dnsInvalid, nil
+	}
+	if ip := legacy.IP.To4(); ip != nil {
+		return dnsV4Std, ip
+	}
+	// tor v2 addresses are OnionCat encoded in ipv6 and no longer work on the tor network
+	if onionCat.Contains(legacy.IP) {
+		return dnsInvalid, nil
+	}
+	return dnsV6Std, legacy.IP
+}
+
+var onionCat = &net.IPNet{IP: net.ParseIP("fd87:d87e:eb43::"), Mask: net.CIDRMask(48, 128)}
+
+// naFromLegacy converts a legacy network address to the addrv2 format
+func naFromLegacy(na *wire.NetAddress) *wire.NetAddressV2 {
+	ip := na.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return wire.NetAddressV2FromBytes(na.Timestamp, na.Services, ip, na.Port)
+}
+
+// naFromHost creates an addrv2 network address from an ip address or tor v3
+// onion host name. nil is returned if the host can not be parsed
+func naFromHost(host string, port uint16, services wire.ServiceFlag, ts time.Time) *wire.NetAddressV2 {
+	if ip := net.ParseIP(host); ip != nil {
+		return naFromLegacy(&wire.NetAddress{Timestamp: ts, Services: services, IP: ip, Port: port})
+	}
+
+	// tor v3 host names are base32(pubkey | checksum | version) + ".onion"
+	if !strings.HasSuffix(host, ".onion") || len(host) != wire.TorV3EncodedSize {
+		return nil
+	}
+	b, err := base32.StdEncoding.DecodeString(strings.ToUpper(strings.TrimSuffix(host, ".onion")))
+	if err != nil || len(b) != wire.TorV3Size+3 {
+		return nil
+	}
+	na := wire.NetAddressV2FromBytes(ts, services, b[:wire.TorV3Size], port)
+	// rebuilding the name checks the checksum & version bytes
+	if na.Addr.String() != host {
+		return nil
+	}
+	return na
+}
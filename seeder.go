@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/ltcsuite/ltcd/chaincfg"
 	"github.com/ltcsuite/ltcd/wire"
+	"github.com/miekg/dns"
 )
 
 const (
@@ -19,34 +23,40 @@ const (
 	minPort = 0
 	maxPort = 65535
 
-	crawlDelay = 22 // seconds between start crawlwer ticks
-	auditDelay = 22 // minutes between audit channel ticks
-	dnsDelay   = 57 // seconds between updates to active dns record list
+	// defaults for the crawl tuning. The network file can override them
+	defaultCrawlDelay = 22   // seconds between start crawlwer ticks
+	defaultAuditDelay = 22   // minutes between audit channel ticks
+	defaultDNSDelay   = 57   // seconds between updates to active dns record list
+	defaultMaxSize    = 1250 // max number of clients before we start restricting new entries
 
-	maxFails = 58 // max number of connect fails before we delete a node. Just over 24 hours(checked every 33 minutes)
+	defaultMaxFails = 58 // max number of connect fails before we delete a node. Just over 24 hours(checked every 33 minutes)
 
-	maxTo = 250 // max seconds (4min 10 sec) for all comms to node to complete before we timeout
+	// sane bounds for the crawl tuning in the network file
+	minMaxSize    = 10
+	maxMaxSize    = 100000
+	maxCrawlStart = 1000             // max goroutines started each run for one status
+	minNodeDelay  = 10               // min seconds between crawls of a node
+	maxNodeDelay  = 7 * 24 * 60 * 60 // max seconds between crawls of a node
+	maxTickDelay  = 3600             // max seconds between crawl & dns ticks
+	maxAuditDelay = 24 * 60          // max minutes between audit ticks
+	maxMaxFails   = 10000
+
+	maxTo = 250 // max seconds (4min 10 sec) for a crawl of a node to complete before we cancel it
+
+	defaultAnswers = 25  // default number of records returned in a dns answer
+	maxAnswers     = 250 // upper limit for the number of records returned in a dns answer
+
+	defaultPool = 10 // default number of records per maxAnswers published for each record type
+
+	tipWindow = time.Hour // only nodes connected this recently count towards the consensus height
 )
 
 const (
 	dnsInvalid  = iota //
 	dnsV4Std           // ip v4 using network standard port
 	dnsV6Std           // ipv6 using network standard port
+	dnsTorV3           // tor v3 onion address. Not served as A or AAAA records
 	maxDNSTypes        // used in main to allocate slice
-	x1                 // NODE_NETWORK (1 << 0)
-	x2                 // NODE_GETUTXO (1 << 1)
-	x4                 // NODE_BLOOM (1 << 2)
-	x5                 // NODE_NETWORK | NODE_BLOOM (0x1 | 0x4)
-	x8                 // NODE_WITNESS (1 << 3)
-	x9                 // NODE_NETWORK | NODE_WITNESS (0x1 | 0x8)
-	x40                // NODE_COMPACT_FILTERS (1 << 6)
-	x41                // NODE_NETWORK | NODE_COMPACT_FILTERS (0x1 | 0x40)
-	x48                // NODE_WITNESS | NODE_COMPACT_FILTERS (0x8 | 0x40)
-	x49                // NODE_NETWORK | NODE_WITNESS | NODE_COMPACT_FILTERS (0x1 | 0x8 | 0x40)
-	x400               // NODE_NETWORK_LIMITED (1 << 10)
-	x1000000           // NODE_MWEB (1 << 24)
-	x1800000           // NODE_MWEB | NODE_MWEB_LIGHT_CLIENT (0x1000000 | 0x800000)
-	x1800049           // NODE_MWEB | NODE_MWEB_LIGHT_CLIENT | NODE_NETWORK | NODE_WITNESS | NODE_COMPACT_FILTERS
 )
 
 const (
@@ -55,44 +65,105 @@ const (
 	statusCG              // confirmed good. We have connected to the node and received addresses
 	statusWG              // was good. node was confirmed good but now having problems
 	statusNG              // no good. Will be removed from theList after 24 hours to redure bouncing ip addresses
+	statusWC              // wrong chain. Node failed the checkpoint headers check and is never served
 	maxStatusTypes        // used in main to allocate slice
 )
 
 type dnsseeder struct {
-	id         wire.BitcoinNet  // Magic number - Unique ID for this network. Sent in header of all messages
-	theList    map[string]*node // the list of current nodes
-	mtx        sync.RWMutex     // protect thelist
-	dnsHost    string           // dns host we will serve results for this domain
-	name       string           // Short name for the network
-	desc       string           // Long description for the network
-	initialIPs []string         // Initial ip addresses to connect to and ask for addresses if we have no seeders
-	seeders    []string         // slice of seeders to pull ip addresses when starting this seeder
-	maxStart   []uint32         // max number of goroutines to start each run for each status type
-	delay      []int64          // number of seconds to wait before we connect to a known client for each status
-	counts     NodeCounts       // structure to hold stats for this seeder
-	pver       uint32           // minimum block height for the seeder
-	ttl        uint32           // DNS TTL to use for this seeder
-	maxSize    int              // max number of clients before we start restricting new entries
-	port       uint16           // default network port this seeder uses
+	id          wire.BitcoinNet  // Magic number - Unique ID for this network. Sent in header of all messages
+	theList     map[string]*node // the list of current nodes
+	mtx         sync.RWMutex     // protect thelist & the settings
+	dnsHost     string           // dns host we will serve results for this domain
+	name        string           // Short name for the network
+	counts      NodeCounts       // structure to hold stats for this seeder
+	metrics     *seederMetrics   // counters exported on the metrics page
+	tipHeight   int32            // consensus chain height from the recent confirmed good nodes
+	chainParams *chaincfg.Params // chain parameters used when talking to nodes
+	bans        []*ban           // banned addresses & subnets added by admins
+	fileBans    []*ban           // banned addresses & subnets loaded from the ban file
+	crawlNow    chan string      // theList keys of nodes to crawl immediately
+	retune      chan struct{}    // signals the seeder goroutine that the settings changed
+	quit        chan struct{}    // closed to shut down the seeder
+	stopped     chan struct{}    // closed when the seeder has shut down
+	netSettings
+}
+
+// netSettings are the parts of the network file that can change while the seeder
+// runs. A reload replaces them under the seeder lock so goroutines that do not
+// hold the lock must work from a copy returned by settings
+type netSettings struct {
+	desc        string        // Long description for the network
+	initialIPs  []string      // Initial ip addresses to connect to and ask for addresses if we have no seeders
+	seeders     []string      // slice of seeders to pull ip addresses when starting this seeder
+	maxStart    []uint32      // max number of goroutines to start each run for each status type
+	delay       []int64       // number of seconds to wait before we connect to a known client for each status
+	pver        uint32        // minimum block height for the seeder
+	ttl         uint32        // DNS TTL to use for this seeder
+	maxSize     int           // max number of clients before we start restricting new entries
+	crawlDelay  time.Duration // time between start crawler ticks
+	auditDelay  time.Duration // time between audit ticks
+	dnsDelay    time.Duration // time between updates to the dns records
+	maxFails    uint32        // max number of connect fails before we delete a NG or WC node
+	maxAnswers  int           // max number of records returned in one dns answer
+	nameServers []string      // authoritative name servers for the dns zone
+	mbox        string        // SOA responsible mailbox in domain name format
+	soaRefresh  uint32        // SOA refresh interval in seconds
+	soaRetry    uint32        // SOA retry interval in seconds
+	soaExpire   uint32        // SOA expire time in seconds
+	soaMinTTL   uint32        // SOA minimum TTL. Used for negative caching
+	onionProxy  string        // socks5 proxy host:port used to crawl tor nodes
+	onionLabel  string        // dns label that tor node TXT records are served under
+	policy      goodPolicy    // requirements a node must meet to be published in dns
+	pool        int           // max number of nodes published for each record type
+	maxBlockLag int32         // max blocks a node can be behind the consensus height. 0 to disable
+	checkpoints []checkpoint  // known blocks used to check nodes are on our chain
+	port        uint16        // default network port this seeder uses
+	conf        JNetwork      // network file the settings were read from
+}
+
+// settings returns a copy of the seeder settings. Slices in the settings are
+// replaced and never changed in place so the copy is safe to use without the lock
+func (s *dnsseeder) settings() netSettings {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.netSettings
+}
+
+// applySettings replaces the settings of the running seeder. Crawls in progress
+// finish with the settings they started with. The seeder goroutine is told so it
+// can reset any tick that changed and the dns records are updated with the new
+// settings now
+func (s *dnsseeder) applySettings(ns netSettings) {
+	s.mtx.Lock()
+	s.netSettings = ns
+	s.mtx.Unlock()
+
+	select {
+	case s.retune <- struct{}{}:
+	default:
+	}
+	s.updateDNS()
 }
 
 type result struct {
-	nas        []*wire.NetAddress // slice of node addresses returned from a node
-	msg        *crawlError        // error string or nil if no problems
-	node       string             // theList key to the node that was crawled
-	version    int32              // remote node protocol version
-	services   wire.ServiceFlag   // remote client supported services
-	lastBlock  int32              // last block seen by the node
-	strVersion string             // remote client user agent
+	nas        []*wire.NetAddressV2 // slice of node addresses returned from a node
+	msg        *crawlError          // error string or nil if no problems
+	node       string               // theList key to the node that was crawled
+	version    int32                // remote node protocol version
+	services   wire.ServiceFlag     // remote client supported services
+	lastBlock  int32                // last block seen by the node
+	strVersion string               // remote client user agent
+	wrongChain string               // reason the node failed the checkpoint check or empty
 }
 
 // initCrawlers needs to be run before the startCrawlers so it can get
 // a list of current ip addresses from the other seeders and therefore
 // start the crawl process
 func (s *dnsseeder) initSeeder() {
+	ns := s.settings()
 
 	// range over existing seeders for the network and get starting ip addresses from them
-	for _, aseeder := range s.seeders {
+	for _, aseeder := range ns.seeders {
 		c := 0
 
 		if aseeder == "" {
@@ -104,60 +175,121 @@ func (s *dnsseeder) initSeeder() {
 			continue
 		}
 
+		s.mtx.Lock()
 		for _, ip := range newRRs {
 			if newIP := net.ParseIP(ip); newIP != nil {
 				// 1 at the end is the services flag
-				if x := s.addNa(wire.NewNetAddressIPPort(newIP, s.port, 1)); x {
+				if x := s.addNa(wire.NewNetAddressIPPort(newIP, ns.port, 1)); x {
 					c++
 				}
 			}
 		}
+		s.mtx.Unlock()
 		if config.verbose {
 			log.Printf("%s: completed import of %v addresses from %s\n", s.name, c, aseeder)
 		}
 	}
 
 	// load ip addresses into system and start crawling from them
-	if len(s.theList) == 0 && len(s.initialIPs) > 0 {
-		for _, initialIP := range s.initialIPs {
-			if newIP := net.ParseIP(initialIP); newIP != nil {
-				// 1 at the end is the services flag
-				if x := s.addNa(wire.NewNetAddressIPPort(newIP, s.port, 1)); x {
-					log.Printf("%s: crawling with initial IP %s \n", s.name, initialIP)
-				}
-			}
-		}
+	if s.nodeCount() == 0 {
+		s.addInitialIPs()
 	}
 
-	if len(s.theList) == 0 {
+	if s.nodeCount() == 0 {
 		log.Printf("%s: Error: No ip addresses from seeders so I have nothing to crawl.\n", s.name)
-		for _, v := range s.seeders {
+		for _, v := range ns.seeders {
 			log.Printf("%s: Seeder: %s\n", s.name, v)
 		}
-		for _, v := range s.initialIPs {
+		for _, v := range ns.initialIPs {
 			log.Printf("%s: Initial IP: %s\n", s.name, v)
 		}
 	}
 }
 
-// runSeeder runs a seeder in an endless goroutine
-func (s *dnsseeder) runSeeder(done <-chan struct{}, wg *sync.WaitGroup) {
+// addInitialIPs adds the initial ip addresses to theList. Addresses can be ip,
+// ip:port or onion:port and the network port is used if no port is given
+func (s *dnsseeder) addInitialIPs() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, initialIP := range s.initialIPs {
+		na, err := s.naFromAddr(initialIP)
+		if err != nil {
+			log.Printf("%s: invalid initial IP: %v\n", s.name, err)
+			continue
+		}
+		if x := s.addNaV2(na); x {
+			log.Printf("%s: crawling with initial IP %s \n", s.name, initialIP)
+		}
+	}
+}
+
+// nodeCount returns the number of nodes in theList
+func (s *dnsseeder) nodeCount() int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return len(s.theList)
+}
+
+// naFromAddr returns the network address for an ip, ip:port or onion:port. The
+// network port is used if no port is given
+func (ns *netSettings) naFromAddr(addr string) (*wire.NetAddressV2, error) {
+	host, port := addr, ns.port
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		pn, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %s", addr)
+		}
+		host, port = h, uint16(pn)
+	}
+	// 1 is the services flag
+	na := naFromHost(host, port, 1, time.Now())
+	if na == nil {
+		return nil, fmt.Errorf("invalid address %s", addr)
+	}
+	return na, nil
+}
+
+// runSeeder runs a seeder in a goroutine until the context is cancelled or the
+// quit channel is closed. Crawls still running when it returns are cancelled
+func (s *dnsseeder) runSeeder(ctx context.Context, wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	defer close(s.stopped)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// receive the results from the crawl goroutines
 	resultsChan := make(chan *result)
 
-	// load data from other seeders so we can start crawling nodes
-	s.initSeeder()
+	// restore theList from the last snapshot unless it was taken over from the
+	// seeder this one replaced. If nothing was restored then load data from
+	// other seeders so we can start crawling nodes
+	if s.nodeCount() == 0 {
+		n, err := s.loadNodes()
+		if err != nil {
+			log.Printf("%s: unable to restore snapshot: %v\n", s.name, err)
+		}
+		if n == 0 {
+			s.initSeeder()
+		}
+	}
 
 	// start initial scan now so we don't have to wait for the timers to fire
-	s.startCrawlers(resultsChan)
+	s.startCrawlers(ctx, resultsChan)
+
+	// publish any restored nodes and the zone SOA & NS records
+	s.loadDNS()
 
-	// create timing channels for regular tasks
-	auditChan := time.NewTicker(time.Minute * auditDelay).C
-	crawlChan := time.NewTicker(time.Second * crawlDelay).C
-	dnsChan := time.NewTicker(time.Second * dnsDelay).C
+	// create timers for regular tasks. A reload can change the delays
+	ns := s.settings()
+	audit := time.NewTicker(ns.auditDelay)
+	defer audit.Stop()
+	crawl := time.NewTicker(ns.crawlDelay)
+	defer crawl.Stop()
+	dnsTick := time.NewTicker(ns.dnsDelay)
+	defer dnsTick.Stop()
 
 	dowhile := true
 	for dowhile {
@@ -165,27 +297,53 @@ func (s *dnsseeder) runSeeder(done <-chan struct{}, wg *sync.WaitGroup) {
 		case r := <-resultsChan:
 			// process a results structure from a crawl
 			s.processResult(r)
-		case <-dnsChan:
+		case <-dnsTick.C:
 			// update the system with the latest selection of dns records
 			s.loadDNS()
-		case <-auditChan:
+		case <-audit.C:
 			// keep theList clean and tidy
 			s.auditNodes()
-		case <-crawlChan:
+			s.pruneBans()
+			if err := s.saveNodes(); err != nil {
+				log.Printf("%s: unable to save snapshot: %v\n", s.name, err)
+			}
+		case <-crawl.C:
 			// start a scan to crawl nodes
-			s.startCrawlers(resultsChan)
-		case <-done:
-			// done channel closed so exit the select and shutdown the seeder
+			s.startCrawlers(ctx, resultsChan)
+		case <-s.retune:
+			// the settings were reloaded so reset any timer whose delay changed
+			nns := s.settings()
+			if nns.auditDelay != ns.auditDelay {
+				audit.Reset(nns.auditDelay)
+			}
+			if nns.crawlDelay != ns.crawlDelay {
+				crawl.Reset(nns.crawlDelay)
+			}
+			if nns.dnsDelay != ns.dnsDelay {
+				dnsTick.Reset(nns.dnsDelay)
+			}
+			ns = nns
+		case k := <-s.crawlNow:
+			// an admin asked for a node to be crawled now
+			s.startCrawl(ctx, resultsChan, k)
+		case <-s.quit:
+			// quit channel closed so exit the select and shutdown the seeder
+			dowhile = false
+		case <-ctx.Done():
+			// the application is shutting down
 			dowhile = false
 		}
 	}
 	fmt.Printf("shutting down seeder: %s\n", s.name)
+	if err := s.saveNodes(); err != nil {
+		log.Printf("%s: unable to save snapshot: %v\n", s.name, err)
+	}
 	// end the goroutine & defer will call wg.Done()
 }
 
 // startCrawlers is called on a time basis to start maxcrawlers new
 // goroutines if there are spare goroutine slots available
-func (s *dnsseeder) startCrawlers(resultsChan chan *result) {
+func (s *dnsseeder) startCrawlers(ctx context.Context, resultsChan chan *result) {
 
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
@@ -225,7 +383,7 @@ func (s *dnsseeder) startCrawlers(resultsChan chan *result) {
 		nd.crawlActive = true
 		nd.crawlStart = time.Now()
 
-		go crawlNode(resultsChan, s, nd)
+		go crawlNode(ctx, resultsChan, s, nd)
 		started[nd.status]++
 	}
 
@@ -236,6 +394,20 @@ func (s *dnsseeder) startCrawlers(resultsChan chan *result) {
 	// returns and read lock released
 }
 
+// startCrawl starts a crawl of one node unless it is already being crawled
+func (s *dnsseeder) startCrawl(ctx context.Context, resultsChan chan *result, k string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	nd, ok := s.theList[k]
+	if !ok || nd.crawlActive {
+		return
+	}
+	nd.crawlActive = true
+	nd.crawlStart = time.Now()
+	go crawlNode(ctx, resultsChan, s, nd)
+}
+
 // processResult will add new nodes to the list and update the status of the crawled node
 func (s *dnsseeder) processResult(r *result) {
 
@@ -253,11 +425,14 @@ func (s *dnsseeder) processResult(r *result) {
 
 	// now nd has been set to a valid pointer we can use it in a defer
 	defer crawlEnd(nd)
+	s.metrics.crawlDone(crawlOutcome(r), time.Since(nd.crawlStart))
 
 	// msg is a crawlerror or nil
 	if r.msg != nil {
 		// update the fact that we have not connected to this node
-		nd.lastTry = time.Now()
+		now := time.Now()
+		nd.updateStats(false, now)
+		nd.lastTry = now
 		nd.connectFails++
 		nd.statusStr = r.msg.Error()
 
@@ -286,8 +461,7 @@ func (s *dnsseeder) processResult(r *result) {
 		if config.verbose {
 			log.Printf("%s: failed crawl node: %s s:r:f: %v:%v:%v %s\n",
 				s.name,
-				net.JoinHostPort(nd.na.IP.String(),
-					strconv.Itoa(int(nd.na.Port))),
+				nodeKey(nd.na),
 				nd.status,
 				nd.rating,
 				nd.connectFails,
@@ -296,7 +470,28 @@ func (s *dnsseeder) processResult(r *result) {
 		return
 	}
 
+	// the node answered but is not on our chain so ignore it and its addresses.
+	// Each wrong chain answer counts as a failure so the audit purges the node
+	// after maxFails of them
+	if r.wrongChain != "" {
+		nd.status = statusWC
+		nd.lastTry = time.Now()
+		nd.lastConnect = nd.lastTry
+		nd.updateStats(false, nd.lastTry)
+		nd.connectFails++
+		nd.statusStr = "wrong chain: " + r.wrongChain
+		nd.version = r.version
+		nd.services = r.services
+		nd.lastBlock = r.lastBlock
+		nd.strVersion = r.strVersion
+		if config.verbose {
+			log.Printf("%s: node %s is on the wrong chain: %s\n", s.name, nodeKey(nd.na), r.wrongChain)
+		}
+		return
+	}
+
 	// succesful connection and addresses received so mark status
+	nd.updateStats(true, time.Now())
 	nd.status = statusCG
 	cs := nd.lastConnect
 	nd.rating = 0
@@ -309,8 +504,11 @@ func (s *dnsseeder) processResult(r *result) {
 	nd.services = r.services
 	nd.lastBlock = r.lastBlock
 	nd.strVersion = r.strVersion
+	if s.behindTip(nd) {
+		nd.statusStr = s.lagStr(nd)
+	}
 
-	added := 0
+	added, banned := 0, 0
 
 	// if we are full then skip adding more possible clients
 	if len(s.theList) < s.maxSize {
@@ -319,8 +517,13 @@ func (s *dnsseeder) processResult(r *result) {
 
 		// loop through all the received network addresses and add to thelist if not present
 		for _, na := range r.nas {
+			// banned addresses gossiped by the node are dropped
+			if s.isBanned(na) {
+				banned++
+				continue
+			}
 			// a new network address so add to the system
-			if x := s.addNa(na); x {
+			if x := s.addNaV2(na); x {
 				if added++; added > oneThird {
 					break
 				}
@@ -329,15 +532,15 @@ func (s *dnsseeder) processResult(r *result) {
 	}
 
 	if config.verbose {
-		log.Printf("%s: crawl done: node: %s s:r:f: %v:%v:%v addr: %v:%v CrawlTime: %s Last connect: %v ago\n",
+		log.Printf("%s: crawl done: node: %s s:r:f: %v:%v:%v addr: %v:%v banned: %v CrawlTime: %s Last connect: %v ago\n",
 			s.name,
-			net.JoinHostPort(nd.na.IP.String(),
-				strconv.Itoa(int(nd.na.Port))),
+			nodeKey(nd.na),
 			nd.status,
 			nd.rating,
 			nd.connectFails,
 			len(r.nas),
 			added,
+			banned,
 			time.Since(nd.crawlStart).String(),
 			time.Since(cs).String())
 	}
@@ -350,13 +553,22 @@ func crawlEnd(nd *node) {
 
 // addNa validates and adds a network address to theList
 func (s *dnsseeder) addNa(nNa *wire.NetAddress) bool {
+	return s.addNaV2(naFromLegacy(nNa))
+}
+
+// addNaV2 validates and adds an addrv2 network address to theList
+func (s *dnsseeder) addNaV2(nNa *wire.NetAddressV2) bool {
 
 	if len(s.theList) > s.maxSize {
 		return false
 	}
 
+	if nNa.Addr == nil {
+		return false
+	}
+
 	// generate the key and add to theList
-	k := net.JoinHostPort(nNa.IP.String(), strconv.Itoa(int(nNa.Port)))
+	k := nodeKey(nNa)
 
 	if _, dup := s.theList[k]; dup {
 		return false
@@ -364,6 +576,9 @@ func (s *dnsseeder) addNa(nNa *wire.NetAddress) bool {
 	if nNa.Port <= minPort || nNa.Port >= maxPort {
 		return false
 	}
+	if s.isBanned(nNa) {
+		return false
+	}
 
 	// if the reported timestamp suggests the netaddress has not been seen in the last 24 hours
 	// then ignore this netaddress
@@ -371,18 +586,19 @@ func (s *dnsseeder) addNa(nNa *wire.NetAddress) bool {
 		return false
 	}
 
+	// work out the network this address is on. ipv4, ipv6 or tor
+	dnsType, ip := addrType(nNa)
+	if dnsType == dnsInvalid {
+		return false
+	}
+
 	nt := node{
 		na:          nNa,
+		ip:          ip,
 		lastConnect: time.Now(),
 		version:     0,
 		status:      statusRG,
-		dnsType:     dnsV4Std,
-	}
-
-	// checks to see if ipv4 addr otherwise set ipv6
-	if x := nt.na.IP.To4(); x == nil {
-		// ipv6
-		nt.dnsType = dnsV6Std
+		dnsType:     dnsType,
 	}
 
 	// add the new node details to theList
@@ -394,22 +610,32 @@ func (s *dnsseeder) addNa(nNa *wire.NetAddress) bool {
 func (s *dnsseeder) auditNodes() {
 	c := 0
 
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
 	// set this early so for this audit run all NG clients will be purged
 	// and space will be made for new, possible CG clients
 	iAmFull := len(s.theList) > s.maxSize
 
 	// cgGoal is 75% of the max statusCG clients we can crawl with the current network delay & maxStart settings.
 	// This allows us to cycle statusCG users to keep the list fresh
-	cgGoal := int(float64(float64(s.delay[statusCG]/crawlDelay)*float64(s.maxStart[statusCG])) * 0.75)
+	cgGoal := int(float64(float64(s.delay[statusCG]/int64(s.crawlDelay/time.Second))*float64(s.maxStart[statusCG])) * 0.75)
 	cgCount := 0
 
 	log.Printf("%s: Audit start. statusCG Goal: %v System Uptime: %s\n", s.name, cgGoal, time.Since(config.uptime).String())
 
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
-
 	for k, nd := range s.theList {
 
+		// banned nodes are never kept
+		if b := s.bannedBy(nd.na); b != nil {
+			if config.verbose {
+				log.Printf("%s: purging node %s banned by %s\n", s.name, k, b.Target)
+			}
+			c++
+			delete(s.theList, k)
+			continue
+		}
+
 		if nd.crawlActive {
 			if time.Now().Unix()-nd.crawlStart.Unix() >= 300 {
 				log.Printf("warning - long running crawl > 5 minutes ====\n- %s status:rating:fails %v:%v:%v crawl start: %s last status: %s\n====\n",
@@ -423,7 +649,7 @@ func (s *dnsseeder) auditNodes() {
 		}
 
 		// Audit task is to remove node that we have not been able to connect to
-		if nd.status == statusNG && nd.connectFails > maxFails {
+		if (nd.status == statusNG || nd.status == statusWC) && nd.connectFails > s.maxFails {
 			if config.verbose {
 				log.Printf("%s: purging node %s after %v failed connections\n", s.name, k, nd.connectFails)
 			}
@@ -435,8 +661,8 @@ func (s *dnsseeder) auditNodes() {
 			delete(s.theList, k)
 		}
 
-		// If seeder is full then remove old NG clients and fill up with possible new CG clients
-		if nd.status == statusNG && iAmFull {
+		// If seeder is full then remove old NG & WC clients and fill up with possible new CG clients
+		if (nd.status == statusNG || nd.status == statusWC) && iAmFull {
 			if config.verbose {
 				log.Printf("%s: seeder full purging node %s\n", s.name, k)
 			}
@@ -472,11 +698,113 @@ func (s *dnsseeder) auditNodes() {
 
 // teatload loads the dns records with time based test data
 func (s *dnsseeder) loadDNS() {
+	s.updateTip()
 	updateDNS(s)
 }
 
+// updateTip sets the consensus chain height to the median last block reported by
+// recently connected confirmed good nodes and counts the nodes lagging behind it
+func (s *dnsseeder) updateTip() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var heights []int32
+	for _, nd := range s.theList {
+		if nd.status == statusCG && nd.lastBlock > 0 && time.Since(nd.lastConnect) < tipWindow {
+			heights = append(heights, nd.lastBlock)
+		}
+	}
+	s.tipHeight = medianHeight(heights)
+
+	var lagging uint32
+	for _, nd := range s.theList {
+		if nd.status == statusCG && s.behindTip(nd) {
+			lagging++
+			nd.statusStr = s.lagStr(nd)
+		}
+	}
+
+	s.counts.mtx.Lock()
+	s.counts.TipHeight = s.tipHeight
+	s.counts.Lagging = lagging
+	s.counts.mtx.Unlock()
+}
+
+// behindTip returns true if the node last block is too far behind the consensus
+// height. The caller must hold the seeder lock
+func (s *dnsseeder) behindTip(nd *node) bool {
+	return s.maxBlockLag > 0 && s.tipHeight > 0 && nd.lastBlock < s.tipHeight-s.maxBlockLag
+}
+
+// lagStr returns the status string for a node that is behind the consensus height
+func (s *dnsseeder) lagStr(nd *node) string {
+	return fmt.Sprintf("lagging: block %d is %d behind consensus height %d", nd.lastBlock, s.tipHeight-nd.lastBlock, s.tipHeight)
+}
+
+// medianHeight returns the median of the heights or 0 if there are none
+func medianHeight(heights []int32) int32 {
+	if len(heights) == 0 {
+		return 0
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights[len(heights)/2]
+}
+
+// shutdown signals the seeder to stop. The stopped channel is closed once the
+// seeder has saved its snapshot and exited
+func (s *dnsseeder) shutdown() {
+	close(s.quit)
+}
+
+// takeOver moves theList and the stats from a stopped seeder for the same network
+// to this seeder so a reload does not lose the crawl history
+func (s *dnsseeder) takeOver(old *dnsseeder) {
+	old.mtx.Lock()
+	defer old.mtx.Unlock()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for k, nd := range old.theList {
+		// crawls in progress were abandoned when the old seeder stopped
+		nd.crawlActive = false
+		s.theList[k] = nd
+	}
+	old.theList = make(map[string]*node)
+	s.tipHeight = old.tipHeight
+	carryHits(s.bans, old.bans)
+	carryHits(s.fileBans, old.fileBans)
+	if old.metrics != nil {
+		s.metrics = old.metrics
+	}
+
+	old.counts.mtx.RLock()
+	defer old.counts.mtx.RUnlock()
+	s.counts.mtx.Lock()
+	defer s.counts.mtx.Unlock()
+	copy(s.counts.NdStatus, old.counts.NdStatus)
+	copy(s.counts.NdStarts, old.counts.NdStarts)
+	copy(s.counts.DNSCounts, old.counts.DNSCounts)
+	s.counts.TipHeight = old.counts.TipHeight
+	s.counts.Lagging = old.counts.Lagging
+}
+
+// activeSeeders returns the running seeders in the order the network files were loaded
+func activeSeeders() []*dnsseeder {
+	config.smtx.RLock()
+	defer config.smtx.RUnlock()
+	seeders := make([]*dnsseeder, 0, len(config.order))
+	for _, n := range config.order {
+		if s, ok := config.seeders[n]; ok {
+			seeders = append(seeders, s)
+		}
+	}
+	return seeders
+}
+
 // getSeederByName returns a pointer to the seeder based on its name or nil if not found
 func getSeederByName(name string) *dnsseeder {
+	config.smtx.RLock()
+	defer config.smtx.RUnlock()
 	for _, s := range config.seeders {
 		if s.name == name {
 			return s
@@ -485,11 +813,30 @@ func getSeederByName(name string) *dnsseeder {
 	return nil
 }
 
-// isDuplicateSeeder returns true if the seeder details already exist in the application
-func isDuplicateSeeder(s *dnsseeder) (bool, error) {
+// getSeederByZone returns a pointer to the seeder that serves the dns name or nil if not found.
+// If zones are nested then the seeder with the longest matching zone is returned
+func getSeederByZone(name string) *dnsseeder {
+	config.smtx.RLock()
+	defer config.smtx.RUnlock()
+	var found *dnsseeder
+	labels := 0
+	for _, s := range config.seeders {
+		zone := dns.Fqdn(s.dnsHost)
+		if n := dns.CountLabel(zone); dns.IsSubDomain(zone, name) && (found == nil || n > labels) {
+			found, labels = s, n
+		}
+	}
+	return found
+}
+
+// isDuplicateSeeder returns true if the seeder details clash with one of the seeders
+func isDuplicateSeeder(s *dnsseeder, seeders map[string]*dnsseeder) (bool, error) {
 
 	// check for duplicate seeders with the same details
-	for _, v := range config.seeders {
+	for _, v := range seeders {
+		if v.name == s.name {
+			return true, fmt.Errorf("duplicate network name %s", s.name)
+		}
 		if v.id == s.id {
 			return true, fmt.Errorf("duplicate Magic id. Already loaded for %s so can not be used for %s\n%s", v.id, v.name, s.name)
 		}
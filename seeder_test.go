@@ -3,7 +3,9 @@ package main
 import (
 	"net"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ltcsuite/ltcd/wire"
 )
@@ -20,9 +22,11 @@ func TestAddnNa(t *testing.T) {
 	}
 
 	s := &dnsseeder{
-		port:    29333,
-		pver:    1234,
-		maxSize: 1,
+		netSettings: netSettings{
+			port:    29333,
+			pver:    1234,
+			maxSize: 1,
+		},
 	}
 	s.theList = make(map[string]*node)
 
@@ -64,3 +68,186 @@ func TestAddnNa(t *testing.T) {
 	}
 
 }
+
+func TestAddNaV2(t *testing.T) {
+	s := &dnsseeder{
+		netSettings: netSettings{
+			port:    9333,
+			maxSize: 10,
+		},
+	}
+	s.theList = make(map[string]*node)
+
+	pubKey := make([]byte, wire.TorV3Size)
+	for i := range pubKey {
+		pubKey[i] = byte(i)
+	}
+	onion := wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork, pubKey, 9333)
+	if !s.addNaV2(onion) {
+		t.Fatalf("failed to add tor v3 address: %s", onion.Addr)
+	}
+	nd := s.theList[nodeKey(onion)]
+	if nd == nil || nd.dnsType != dnsTorV3 || nd.ip != nil {
+		t.Errorf("tor v3 node not stored as an onion node: %+v", nd)
+	}
+
+	// the onion host name can be converted back to the same address
+	na := naFromHost(onion.Addr.String(), 9333, wire.SFNodeNetwork, time.Now())
+	if na == nil || nodeKey(na) != nodeKey(onion) {
+		t.Errorf("unable to parse onion host %s", onion.Addr)
+	}
+	if naFromHost("aaaa"+onion.Addr.String()[4:], 9333, 0, time.Now()) != nil {
+		t.Errorf("onion host with a bad checksum was parsed")
+	}
+
+	// tor v2 addresses are no longer usable
+	torV2 := wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork, pubKey[:10], 9333)
+	if s.addNaV2(torV2) {
+		t.Errorf("tor v2 address should not be added: %s", torV2.Addr)
+	}
+
+	ip4 := wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork, net.ParseIP("1.2.3.4").To4(), 9333)
+	if !s.addNaV2(ip4) {
+		t.Fatalf("failed to add ipv4 address")
+	}
+	if nd := s.theList["1.2.3.4:9333"]; nd == nil || nd.dnsType != dnsV4Std {
+		t.Errorf("ipv4 node not stored with the legacy key")
+	}
+}
+
+func TestNodeStats(t *testing.T) {
+	nd := &node{}
+	now := time.Now()
+
+	// a new node is reliable until it fails more crawls than it passes
+	if !nd.reliable() {
+		t.Errorf("new node should be reliable")
+	}
+
+	// crawl every 15 minutes for a day and always succeed
+	for i := 0; i < 96; i++ {
+		nd.updateStats(true, now)
+		nd.lastTry = now
+		now = now.Add(15 * time.Minute)
+	}
+	if nd.total != 96 || nd.success != 96 {
+		t.Errorf("unexpected crawl counts %d/%d", nd.success, nd.total)
+	}
+	if u := nd.uptime(stat2H); u < 99 {
+		t.Errorf("2h uptime %.2f%% for an always up node", u)
+	}
+	if !nd.reliable() {
+		t.Errorf("always up node should be reliable")
+	}
+
+	// the node goes down for 8 hours. The short windows drop quickly while the
+	// longer windows still remember the good history
+	for i := 0; i < 32; i++ {
+		nd.updateStats(false, now)
+		nd.lastTry = now
+		now = now.Add(15 * time.Minute)
+	}
+	if u := nd.uptime(stat2H); u > 5 {
+		t.Errorf("2h uptime %.2f%% after 8 hours down", u)
+	}
+	if nd.uptime(stat8H) <= nd.uptime(stat2H) {
+		t.Errorf("8h uptime %.2f%% should be above 2h uptime %.2f%%", nd.uptime(stat8H), nd.uptime(stat2H))
+	}
+	if nd.reliable() {
+		t.Errorf("node down for 8 hours should not be reliable: %+v", nd.stats)
+	}
+}
+
+func TestBehindTip(t *testing.T) {
+	s := &dnsseeder{name: "TestNet", netSettings: netSettings{maxSize: 10, maxBlockLag: 10}}
+	s.theList = make(map[string]*node)
+	s.counts.NdStatus = make([]uint32, maxStatusTypes)
+
+	for i, h := range []int32{1000, 1001, 1002, 1003, 950} {
+		s.addNa(wire.NewNetAddressIPPort(net.IPv4(1, 2, 3, byte(i+1)), 9333, 1))
+		nd := s.theList[net.JoinHostPort(net.IPv4(1, 2, 3, byte(i+1)).String(), "9333")]
+		nd.status = statusCG
+		nd.lastConnect = time.Now()
+		nd.lastBlock = h
+	}
+	// nodes not connected recently do not count towards the consensus height
+	s.addNa(wire.NewNetAddressIPPort(net.IPv4(1, 2, 3, 9), 9333, 1))
+	stale := s.theList["1.2.3.9:9333"]
+	stale.status = statusCG
+	stale.lastConnect = time.Now().Add(-2 * tipWindow)
+	stale.lastBlock = 10
+
+	s.updateTip()
+	if s.tipHeight != 1001 {
+		t.Errorf("consensus height %d, expected 1001", s.tipHeight)
+	}
+	if s.counts.Lagging != 2 {
+		t.Errorf("%d lagging nodes, expected 2", s.counts.Lagging)
+	}
+
+	lag := s.theList["1.2.3.5:9333"]
+	if !s.behindTip(lag) || s.goodNode(lag) {
+		t.Errorf("node 51 blocks behind should not be good")
+	}
+	if !strings.HasPrefix(lag.statusStr, "lagging") {
+		t.Errorf("lagging node status not updated: %s", lag.statusStr)
+	}
+	if nd := s.theList["1.2.3.1:9333"]; s.behindTip(nd) || !s.goodNode(nd) {
+		t.Errorf("node 1 block behind should be good")
+	}
+
+	// the check can be disabled
+	s.maxBlockLag = 0
+	if s.behindTip(lag) {
+		t.Errorf("node lagging with the check disabled")
+	}
+}
+
+func TestGetSeederByZone(t *testing.T) {
+	main := &dnsseeder{name: "main", dnsHost: "seed.example.com"}
+	test := &dnsseeder{name: "test", dnsHost: "testnet.seed.example.com"}
+	setTestSeeders(map[string]*dnsseeder{main.name: main, test.name: test}, []string{main.name, test.name})
+	defer setTestSeeders(nil, nil)
+
+	// map order is random so check each name a few times
+	for i := 0; i < 20; i++ {
+		for name, want := range map[string]*dnsseeder{
+			"seed.example.com.":               main,
+			"x9.seed.example.com.":            main,
+			"testnet.seed.example.com.":       test,
+			"x9.TESTNET.seed.example.com.":    test,
+			"onion.testnet.seed.example.com.": test,
+			"othertestnet.seed.example.com.":  main,
+			"example.com.":                    nil,
+			"testnet.seed.example.com.evil.":  nil,
+		} {
+			if got := getSeederByZone(name); got != want {
+				t.Fatalf("%s served by %v, expected %v", name, got, want)
+			}
+		}
+	}
+}
+
+func TestWrongChainPurged(t *testing.T) {
+	s := &dnsseeder{name: "TestNet"}
+	if err := s.setTuning(JNetwork{MaxFails: 2}); err != nil {
+		t.Fatalf("unable to set tuning: %v", err)
+	}
+	s.theList = make(map[string]*node)
+	s.metrics = newSeederMetrics()
+	s.addNa(wire.NewNetAddressIPPort(net.ParseIP("1.2.3.4"), 9333, 1))
+
+	// a node that keeps answering from the wrong chain is purged after maxFails
+	const k = "1.2.3.4:9333"
+	for i := 1; i <= 3; i++ {
+		s.theList[k].crawlActive = true
+		s.processResult(&result{node: k, lastBlock: 10, wrongChain: "header 11 does not connect"})
+		if nd := s.theList[k]; nd.status != statusWC || nd.connectFails != uint32(i) {
+			t.Fatalf("result %d: status %s with %d fails", i, status2str(nd.status), nd.connectFails)
+		}
+		s.auditNodes()
+		if _, ok := s.theList[k]; ok != (i < 3) {
+			t.Fatalf("result %d: node kept %v", i, ok)
+		}
+	}
+}
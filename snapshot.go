@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+// snapshotVersion is bumped whenever the on-disk layout of a snapshot changes.
+// Version 2 added tor onion hosts in IP and the Total, Success & Stats uptime
+// fields. Version 1 snapshots load with empty uptime statistics. Snapshots with
+// any other version are ignored when loading
+const (
+	snapshotVersion    = 2
+	minSnapshotVersion = 1
+)
+
+// jSnapshot is the on-disk format of a seeder's theList
+type jSnapshot struct {
+	Version uint32
+	Network string
+	ID      wire.BitcoinNet
+	Saved   time.Time
+	Nodes   []jNode
+}
+
+// jNode holds the saved state of one node from theList
+type jNode struct {
+	IP           string
+	Port         uint16
+	Timestamp    time.Time
+	NaServices   wire.ServiceFlag
+	Status       uint32
+	Rating       uint32
+	ConnectFails uint32
+	LastConnect  time.Time
+	LastTry      time.Time
+	StatusStr    string
+	StrVersion   string
+	Services     wire.ServiceFlag
+	Version      int32
+	LastBlock    int32
+	Total        uint32
+	Success      uint32
+	Stats        []jStat
+}
+
+// jStat holds the saved uptime statistics for one window. Snapshots without
+// statistics load with empty statistics
+type jStat struct {
+	Weight      float64
+	Count       float64
+	Reliability float64
+}
+
+// snapshotFile returns the file name used to store the snapshot for this seeder
+// or an empty string if no data directory has been configured
+func (s *dnsseeder) snapshotFile() string {
+	if config.datadir == "" {
+		return ""
+	}
+	return filepath.Join(config.datadir, s.name+".nodes.json")
+}
+
+// saveNodes writes the current state of theList to disk. The file is written to a
+// temporary file first and then renamed so a crash never leaves a partial snapshot
+func (s *dnsseeder) saveNodes() error {
+	fName := s.snapshotFile()
+	if fName == "" {
+		return nil
+	}
+
+	snap := jSnapshot{
+		Version: snapshotVersion,
+		Network: s.name,
+		ID:      s.id,
+		Saved:   time.Now(),
+	}
+
+	s.mtx.RLock()
+	snap.Nodes = make([]jNode, 0, len(s.theList))
+	for _, nd := range s.theList {
+		stats := make([]jStat, len(nd.stats))
+		for i, st := range nd.stats {
+			stats[i] = jStat{Weight: st.weight, Count: st.count, Reliability: st.reliability}
+		}
+		snap.Nodes = append(snap.Nodes, jNode{
+			IP:           nd.na.Addr.String(),
+			Port:         nd.na.Port,
+			Timestamp:    nd.na.Timestamp,
+			NaServices:   nd.na.Services,
+			Status:       nd.status,
+			Rating:       nd.rating,
+			ConnectFails: nd.connectFails,
+			LastConnect:  nd.lastConnect,
+			LastTry:      nd.lastTry,
+			StatusStr:    nd.statusStr,
+			StrVersion:   nd.strVersion,
+			Services:     nd.services,
+			Version:      nd.version,
+			LastBlock:    nd.lastBlock,
+			Total:        nd.total,
+			Success:      nd.success,
+			Stats:        stats,
+		})
+	}
+	s.mtx.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(fName), filepath.Base(fName)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating snapshot file: %v", err)
+	}
+	// remove the temp file on any error. After a successful rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error encoding snapshot: %v", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing snapshot file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("error closing snapshot file: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), fName); err != nil {
+		return fmt.Errorf("error renaming snapshot file: %v", err)
+	}
+
+	if config.verbose {
+		log.Printf("%s: saved %v nodes to %s\n", s.name, len(snap.Nodes), fName)
+	}
+	return nil
+}
+
+// loadNodes restores theList from the snapshot on disk and returns the number of
+// nodes loaded. A missing snapshot is not an error
+func (s *dnsseeder) loadNodes() (int, error) {
+	fName := s.snapshotFile()
+	if fName == "" {
+		return 0, nil
+	}
+
+	f, err := os.Open(fName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading snapshot file: %v", err)
+	}
+	defer f.Close()
+
+	var snap jSnapshot
+	if err = json.NewDecoder(f).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("error decoding snapshot file: %v", err)
+	}
+
+	if snap.Version < minSnapshotVersion || snap.Version > snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %v. Expected %v to %v", snap.Version, minSnapshotVersion, snapshotVersion)
+	}
+	if snap.ID != s.id {
+		return 0, fmt.Errorf("snapshot is for network %s not %s", snap.ID, s.id)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	c := 0
+	for _, jn := range snap.Nodes {
+		if len(s.theList) > s.maxSize {
+			break
+		}
+
+		// IP holds the onion host name for tor nodes
+		na := naFromHost(jn.IP, jn.Port, jn.NaServices, jn.Timestamp)
+		if na == nil || jn.Status >= maxStatusTypes {
+			continue
+		}
+		// nodes banned since the snapshot was saved are not restored
+		if s.bannedBy(na) != nil {
+			continue
+		}
+
+		dnsType, ip := addrType(na)
+		if dnsType == dnsInvalid {
+			continue
+		}
+
+		k := nodeKey(na)
+		if _, dup := s.theList[k]; dup {
+			continue
+		}
+
+		nd := &node{
+			na:           na,
+			ip:           ip,
+			lastConnect:  jn.LastConnect,
+			lastTry:      jn.LastTry,
+			statusStr:    jn.StatusStr,
+			strVersion:   jn.StrVersion,
+			services:     jn.Services,
+			connectFails: jn.ConnectFails,
+			version:      jn.Version,
+			lastBlock:    jn.LastBlock,
+			status:       jn.Status,
+			rating:       jn.Rating,
+			dnsType:      dnsType,
+			total:        jn.Total,
+			success:      jn.Success,
+		}
+		for i := 0; i < len(jn.Stats) && i < len(nd.stats); i++ {
+			st := jn.Stats[i]
+			nd.stats[i] = addrStat{weight: st.Weight, count: st.Count, reliability: st.Reliability}
+		}
+
+		s.theList[k] = nd
+		c++
+	}
+
+	log.Printf("%s: restored %v nodes from snapshot saved %s ago\n", s.name, c, time.Since(snap.Saved).String())
+	return c, nil
+}
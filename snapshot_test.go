@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ltcsuite/ltcd/wire"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	config.datadir = t.TempDir()
+	defer func() { config.datadir = "" }()
+
+	s := &dnsseeder{
+		name: "TestNet",
+		id:   wire.BitcoinNet(0xf1c8d2fd),
+		netSettings: netSettings{
+			port:    19335,
+			maxSize: 10,
+		},
+	}
+	s.theList = make(map[string]*node)
+
+	s.addNa(wire.NewNetAddressIPPort(net.ParseIP("1.2.3.4"), 19335, 1))
+	s.addNa(wire.NewNetAddressIPPort(net.ParseIP("2001:db8::1"), 19335, 1))
+
+	lc := time.Now().Add(-time.Hour).Round(time.Second)
+	nd := s.theList["1.2.3.4:19335"]
+	nd.status = statusCG
+	nd.lastConnect = lc
+	nd.strVersion = "/Satoshi:0.21.2/"
+	nd.lastBlock = 2500000
+	nd.updateStats(true, time.Now())
+	nd.updateStats(false, time.Now())
+
+	if err := s.saveNodes(); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	r := &dnsseeder{name: s.name, id: s.id, netSettings: netSettings{port: s.port, maxSize: 10}}
+	r.theList = make(map[string]*node)
+
+	n, err := r.loadNodes()
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("restored %d nodes, expected 2", n)
+	}
+
+	rn, ok := r.theList["1.2.3.4:19335"]
+	if !ok {
+		t.Fatalf("node 1.2.3.4:19335 missing after restore")
+	}
+	if rn.status != statusCG || !rn.lastConnect.Equal(lc) || rn.strVersion != nd.strVersion || rn.lastBlock != nd.lastBlock {
+		t.Errorf("restored node does not match saved node: %+v", rn)
+	}
+	if rn.total != 2 || rn.success != 1 || rn.stats != nd.stats {
+		t.Errorf("restored node uptime statistics do not match: %+v %+v", rn.stats, nd.stats)
+	}
+	if r.theList["[2001:db8::1]:19335"].dnsType != dnsV6Std {
+		t.Errorf("restored ipv6 node has wrong dns type")
+	}
+
+	// a snapshot from a different network must not be loaded
+	o := &dnsseeder{name: s.name, id: wire.BitcoinNet(0xdbb6c0fb), netSettings: netSettings{maxSize: 10}}
+	o.theList = make(map[string]*node)
+	if _, err := o.loadNodes(); err == nil {
+		t.Errorf("snapshot loaded for the wrong network")
+	}
+
+	// banned nodes are not restored
+	b, err := newBan("1.2.3.4", "abuse", "ops", nil)
+	if err != nil {
+		t.Fatalf("unable to create ban: %v", err)
+	}
+	bn := &dnsseeder{name: s.name, id: s.id, bans: []*ban{b}, netSettings: netSettings{maxSize: 10}}
+	bn.theList = make(map[string]*node)
+	if n, err := bn.loadNodes(); err != nil || n != 1 || bn.theList["1.2.3.4:19335"] != nil {
+		t.Errorf("restored %d nodes with a ban, error %v", n, err)
+	}
+}
+
+func TestSnapshotVersions(t *testing.T) {
+	config.datadir = t.TempDir()
+	defer func() { config.datadir = "" }()
+
+	s := &dnsseeder{name: "TestNet", id: wire.BitcoinNet(0xf1c8d2fd), netSettings: netSettings{maxSize: 10}}
+	s.theList = make(map[string]*node)
+	fName := s.snapshotFile()
+	seen := time.Now().UTC().Format(time.RFC3339)
+
+	// version 1 snapshots have no uptime statistics
+	v1 := `{"Version":1,"Network":"TestNet","ID":4056470269,"Nodes":[{"IP":"1.2.3.4","Port":19335,"Timestamp":"` +
+		seen + `","Status":1,"LastConnect":"` + seen + `"}]}`
+	if err := os.WriteFile(fName, []byte(v1), 0600); err != nil {
+		t.Fatalf("unable to write snapshot: %v", err)
+	}
+	if n, err := s.loadNodes(); err != nil || n != 1 {
+		t.Fatalf("version 1 snapshot not loaded: %d nodes %v", n, err)
+	}
+	if nd := s.theList["1.2.3.4:19335"]; nd.status != statusCG || nd.total != 0 || nd.stats != [maxStatWindows]addrStat{} {
+		t.Errorf("version 1 node restored as %+v", nd)
+	}
+
+	if err := os.WriteFile(fName, []byte(`{"Version":3,"ID":4056470269}`), 0600); err != nil {
+		t.Fatalf("unable to write snapshot: %v", err)
+	}
+	if _, err := s.loadNodes(); err == nil {
+		t.Errorf("snapshot from a newer version loaded")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// web user roles. Read only users can view every page and admin users can also
+// change the running seeders
+const (
+	roleNone = iota
+	roleRead
+	roleAdmin
+)
+
+// webUser is the authenticated user of a web request
+type webUser struct {
+	name string
+	role int
+}
+
+type webUserKey struct{}
+
+// webAuth checks the credentials sent with web requests. Users are loaded from a
+// htpasswd file for basic auth and a token file for bearer tokens
+type webAuth struct {
+	users  map[string]string // htpasswd user name to password hash
+	tokens map[string]string // bearer token to token name
+	admins map[string]bool   // user & token names with the admin role
+}
+
+// loadWebAuth loads the htpasswd & token files. admins is a comma separated list
+// of the user & token names with the admin role. nil is returned if there are no
+// files so all requests are allowed read only
+func loadWebAuth(htpasswd, tokens, admins string) (*webAuth, error) {
+	if htpasswd == "" && tokens == "" {
+		if admins != "" {
+			return nil, fmt.Errorf("web admins need a htpasswd or token file")
+		}
+		return nil, nil
+	}
+
+	a := &webAuth{
+		users:  make(map[string]string),
+		tokens: make(map[string]string),
+		admins: make(map[string]bool),
+	}
+	if htpasswd != "" {
+		err := readAuthFile(htpasswd, func(name, hash string) error {
+			if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+				return fmt.Errorf("unsupported password hash for user %s. Use bcrypt (htpasswd -B) or {SHA}", name)
+			}
+			a.users[name] = hash
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tokens != "" {
+		err := readAuthFile(tokens, func(name, token string) error {
+			if len(token) < 16 {
+				return fmt.Errorf("token %s is shorter than 16 characters", name)
+			}
+			if _, ok := a.tokens[token]; ok {
+				return fmt.Errorf("token %s is a duplicate", name)
+			}
+			a.tokens[token] = name
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range strings.Split(admins, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			a.admins[n] = true
+		}
+	}
+	return a, nil
+}
+
+// hasAdmins returns true if any user or token has the admin role
+func (a *webAuth) hasAdmins() bool {
+	return a != nil && len(a.admins) > 0
+}
+
+// readAuthFile calls f with the name & value of each name:value line in the file.
+// Blank lines & lines starting with # are skipped
+func readAuthFile(file string, f func(name, value string) error) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open auth file: %v", err)
+	}
+	defer fh.Close()
+
+	sc := bufio.NewScanner(fh)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" || value == "" {
+			return fmt.Errorf("%s line %d is not in the name:value format", file, n)
+		}
+		if err := f(name, value); err != nil {
+			return fmt.Errorf("%s line %d: %v", file, n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// authenticate returns the user for the credentials in the request
+func (a *webAuth) authenticate(r *http.Request) (webUser, bool) {
+	if a == nil {
+		// no auth configured so anyone can read but only known users can be admins
+		return webUser{name: "anonymous", role: roleRead}, true
+	}
+
+	var name string
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, found := a.users[user]
+		if !found || !checkPassword(hash, pass) {
+			return webUser{}, false
+		}
+		name = user
+	} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		name, ok = a.checkToken(strings.TrimSpace(token))
+		if !ok {
+			return webUser{}, false
+		}
+	} else {
+		return webUser{}, false
+	}
+
+	if a.admins[name] {
+		return webUser{name: name, role: roleAdmin}, true
+	}
+	return webUser{name: name, role: roleRead}, true
+}
+
+// checkToken returns the name of a bearer token. All tokens are compared in
+// constant time so the response time does not leak how much of a token matched
+func (a *webAuth) checkToken(token string) (string, bool) {
+	var name string
+	for t, n := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	return name, name != ""
+}
+
+// checkPassword returns true if the password matches a bcrypt or {SHA} htpasswd hash
+func checkPassword(hash, pass string) bool {
+	if sha, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(sha), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// handler requires every request to next to be from a user with at least the
+// read only role. The user is added to the request context
+func (a *webAuth) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := a.authenticate(r)
+		if !ok || u.role < roleRead {
+			if len(a.users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="dnsseeder", charset="UTF-8"`)
+			}
+			if len(a.tokens) > 0 {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="dnsseeder"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), webUserKey{}, u)))
+	})
+}
+
+// requestUser returns the user that made the request
+func requestUser(r *http.Request) webUser {
+	u, _ := r.Context().Value(webUserKey{}).(webUser)
+	return u
+}
+
+// requireAdmin returns true if the request is from an admin user. Otherwise a
+// forbidden error is written. Requests browsers made for other sites are refused
+// as the browser may send the credentials of an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if requestUser(r).role < roleAdmin {
+		apiError(w, r, http.StatusForbidden, "admin role required")
+		return false
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		apiError(w, r, http.StatusForbidden, "cross site admin requests are not allowed")
+		return false
+	}
+	return true
+}